@@ -0,0 +1,506 @@
+// Package analysis finds likely mistakes in programs without running them.
+//
+// Check resolves a program and runs the rules in Rules over it: names that
+// are not defined, variables that are never used or that shadow others,
+// code that can never run, builtins called with the wrong number of
+// arguments and operators applied to literals of types they do not take.
+// Each rule reports diagnostics with the name of the rule as their code,
+// and can be turned on or off by that name.
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/resolver"
+)
+
+// Names of the rules, used as the codes of their diagnostics.
+const (
+	RuleUndefined            = resolver.CodeUndefined
+	RuleDuplicateDeclaration = resolver.CodeDuplicateDeclaration
+	RuleUnused               = "unused"
+	RuleShadow               = "shadow"
+	RuleUnreachable          = "unreachable"
+	RuleArity                = "arity"
+	RuleTypeMismatch         = "type-mismatch"
+)
+
+// Rule describes a check run by Check.
+type Rule struct {
+	Name    string
+	Doc     string
+	Default bool // whether the rule runs unless it is turned off
+}
+
+// Rules are the rules Check knows, in the order they are listed to users.
+var Rules = []Rule{
+	{RuleUndefined, "names that are not defined", true},
+	{RuleDuplicateDeclaration, "parameters, loop variables and variables declared twice", true},
+	{RuleUnused, "variables declared with let and never used", true},
+	{RuleShadow, "variables hiding a variable of an enclosing function or a builtin", false},
+	{RuleUnreachable, "statements after return, break or continue", true},
+	{RuleArity, "builtins called with the wrong number of arguments", true},
+	{RuleTypeMismatch, "operators applied to literals of types they do not take", true},
+}
+
+// LookupRule returns the rule called name.
+func LookupRule(name string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Config selects the rules Check runs.
+type Config struct {
+	Rules   map[string]bool // turns rules on or off by name; others keep their default
+	Globals []string        // names defined by the host, like the args of scripts
+}
+
+func (c Config) enabled(name string) bool {
+	if on, ok := c.Rules[name]; ok {
+		return on
+	}
+	rule, _ := LookupRule(name)
+	return rule.Default
+}
+
+// Check resolves program, setting the bindings of its identifiers, and
+// returns the diagnostics of the rules config enables, sorted by position.
+func Check(program *ast.Program, config Config) []diagnostic.Diagnostic {
+	env := object.NewEnvironment()
+	for _, name := range config.Globals {
+		env.Declare(name)
+	}
+
+	c := &checker{config: config, used: make(map[variableKey]bool)}
+	for _, d := range resolver.Resolve(program, env, isBuiltin) {
+		if config.enabled(d.Code) {
+			c.diags = append(c.diags, d)
+		}
+	}
+
+	global := &scope{names: make(map[string]*ast.Identifier)}
+	for _, name := range config.Globals {
+		global.names[name] = nil
+	}
+	c.scopes = []*scope{global}
+	c.stmts(program.Statements)
+	c.unused()
+	c.shadowed()
+
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		return c.diags[i].Pos.Offset < c.diags[j].Pos.Offset
+	})
+	return c.diags
+}
+
+func isBuiltin(name string) bool {
+	return object.GetBuiltinByName(name) != nil
+}
+
+// scope is the program scope or the scope of a function literal, as the
+// resolver sees them.
+type scope struct {
+	parent *scope
+	names  map[string]*ast.Identifier // first declaration of each name, nil for globals
+}
+
+// lookup returns the innermost of s and its enclosing scopes declaring
+// name, or nil.
+func (s *scope) lookup(name string) *scope {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.names[name]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+type variableKey struct {
+	scope *scope
+	slot  int
+}
+
+// variable is a variable declared with let.
+type variable struct {
+	decl *ast.Identifier
+	key  variableKey
+}
+
+// declaration is a variable, parameter or loop variable declared in scope.
+type declaration struct {
+	ident *ast.Identifier
+	scope *scope
+}
+
+// checker walks a resolved program once, reporting what it can on the way
+// and recording the declarations and uses of variables for the rules that
+// need the whole program.
+type checker struct {
+	config Config
+	scopes []*scope
+	vars   []*variable
+	used   map[variableKey]bool // uses may come before declarations
+	decls  []declaration
+	diags  []diagnostic.Diagnostic
+}
+
+func (c *checker) top() *scope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+// stmts checks a list of statements, reporting those following a
+// statement that never completes.
+func (c *checker) stmts(stmts []ast.Statement) {
+	for i, stmt := range stmts {
+		c.stmt(stmt)
+		if i+1 < len(stmts) && terminates(stmt) {
+			last := stmts[len(stmts)-1]
+			c.report(RuleUnreachable, diagnostic.Warning, stmts[i+1], last, "unreachable code")
+			for _, rest := range stmts[i+1:] {
+				c.stmt(rest)
+			}
+			return
+		}
+	}
+}
+
+// terminates reports whether control never goes on after stmt: it
+// returns, leaves or restarts a loop, or is an if expression whose
+// branches all do.
+func terminates(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	case *ast.ExpressionStatement:
+		ifExpr, ok := stmt.Expression.(*ast.IfExpression)
+		return ok && ifExpr.Alternative != nil && blockTerminates(ifExpr.Consequence) && blockTerminates(ifExpr.Alternative)
+	}
+	return false
+}
+
+func blockTerminates(block *ast.BlockStatement) bool {
+	for _, stmt := range block.Statements {
+		if terminates(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) stmt(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		c.expr(stmt.Expression)
+	case *ast.LetStatement:
+		c.expr(stmt.Value)
+		c.declare(stmt.Name, true)
+	case *ast.ReturnStatement:
+		c.expr(stmt.ReturnValue)
+	case *ast.WhileStatement:
+		c.expr(stmt.Condition)
+		c.block(stmt.Body)
+	case *ast.ForStatement:
+		c.expr(stmt.Iterable)
+		c.declare(stmt.Key, false)
+		c.declare(stmt.Value, false)
+		c.block(stmt.Body)
+	}
+}
+
+func (c *checker) block(block *ast.BlockStatement) {
+	if block != nil {
+		c.stmts(block.Statements)
+	}
+}
+
+// declare records the declaration of ident in the current scope. Only
+// variables declared with let can be unused.
+func (c *checker) declare(ident *ast.Identifier, let bool) {
+	if ident == nil {
+		return
+	}
+	s := c.top()
+	if _, ok := s.names[ident.Value]; ok {
+		return
+	}
+	s.names[ident.Value] = ident
+	c.decls = append(c.decls, declaration{ident: ident, scope: s})
+	if let {
+		c.vars = append(c.vars, &variable{decl: ident, key: variableKey{s, ident.Binding.Slot}})
+	}
+}
+
+// use marks the variable ident refers to as used.
+func (c *checker) use(ident *ast.Identifier) {
+	if ident.Binding.Kind != ast.Variable || ident.Binding.Depth >= len(c.scopes) {
+		return
+	}
+	s := c.scopes[len(c.scopes)-1-ident.Binding.Depth]
+	c.used[variableKey{s, ident.Binding.Slot}] = true
+}
+
+// expr checks expr and returns the type of its value if it is known from
+// the literals in it, and "" otherwise.
+func (c *checker) expr(expr ast.Expression) object.ObjectType {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		c.use(expr)
+	case *ast.IntegerLiteral:
+		return object.INTEGER_OBJ
+	case *ast.FloatLiteral:
+		return object.FLOAT_OBJ
+	case *ast.Boolean:
+		return object.BOOLEAN_OBJ
+	case *ast.StringLiteral:
+		return object.STRING_OBJ
+	case *ast.InterpolatedString:
+		for _, part := range expr.Parts {
+			c.expr(part)
+		}
+		return object.STRING_OBJ
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			c.expr(el)
+		}
+		return object.ARRAY_OBJ
+	case *ast.HashLiteral:
+		for _, key := range expr.Keys {
+			c.expr(key)
+			c.expr(expr.Pairs[key])
+		}
+		return object.HASH_OBJ
+	case *ast.PrefixExpression:
+		return c.prefix(expr, c.expr(expr.Right))
+	case *ast.InfixExpression:
+		return c.infix(expr, c.expr(expr.Left), c.expr(expr.Right))
+	case *ast.AssignExpression:
+		// assigning to a variable does not use it
+		if _, ok := expr.Target.(*ast.Identifier); !ok {
+			c.expr(expr.Target)
+		}
+		c.expr(expr.Value)
+	case *ast.IfExpression:
+		c.expr(expr.Condition)
+		c.block(expr.Consequence)
+		c.block(expr.Alternative)
+	case *ast.FunctionLiteral:
+		c.scopes = append(c.scopes, &scope{parent: c.top(), names: make(map[string]*ast.Identifier)})
+		for _, param := range expr.Parameters {
+			c.declare(param, false)
+		}
+		c.block(expr.Body)
+		c.scopes = c.scopes[:len(c.scopes)-1]
+		return object.FUNCTION_OBJ
+	case *ast.CallExpression:
+		c.expr(expr.Function)
+		for _, arg := range expr.Arguments {
+			c.expr(arg)
+		}
+		c.arity(expr)
+	case *ast.IndexExpression:
+		c.expr(expr.Left)
+		c.expr(expr.Index)
+	}
+	return ""
+}
+
+// ============================================================================
+// Rules
+
+// unused reports the variables declared with let that are never used.
+// Names starting with an underscore are meant to be unused.
+func (c *checker) unused() {
+	for _, v := range c.vars {
+		if !c.used[v.key] && !strings.HasPrefix(v.decl.Value, "_") {
+			c.report(RuleUnused, diagnostic.Warning, v.decl, v.decl, fmt.Sprintf("%s declared and not used", v.decl.Value))
+		}
+	}
+}
+
+// shadowed reports the declarations hiding a variable of an enclosing
+// scope, which the resolver lets them see even if it is declared after
+// them, or a builtin.
+func (c *checker) shadowed() {
+	for _, d := range c.decls {
+		name := d.ident.Value
+		if s := d.scope.parent.lookup(name); s != nil {
+			var hints []string
+			if outer := s.names[name]; outer != nil {
+				hints = append(hints, fmt.Sprintf("shadowed declaration at %s", outer.Pos()))
+			}
+			c.report(RuleShadow, diagnostic.Warning, d.ident, d.ident, fmt.Sprintf("declaration of %s shadows a variable of an enclosing scope", name), hints...)
+		} else if isBuiltin(name) {
+			c.report(RuleShadow, diagnostic.Warning, d.ident, d.ident, fmt.Sprintf("declaration of %s shadows the builtin %s", name, name))
+		}
+	}
+}
+
+// variadic is the maximum number of arguments of builtins taking any
+// number.
+const variadic = -1
+
+// arities are the least and most arguments each builtin takes.
+var arities = map[string][2]int{
+	"len":         {1, 1},
+	"first":       {1, 1},
+	"last":        {1, 1},
+	"rest":        {1, 1},
+	"push":        {2, 2},
+	"puts":        {0, variadic},
+	"exit":        {0, 1},
+	"int":         {1, 1},
+	"float":       {1, 1},
+	"keys":        {1, 1},
+	"values":      {1, 1},
+	"entries":     {1, 1},
+	"has":         {2, 2},
+	"delete":      {2, 2},
+	"set":         {3, 3},
+	"merge":       {1, variadic},
+	"map":         {2, 2},
+	"filter":      {2, 2},
+	"reduce":      {2, 3},
+	"each":        {2, 2},
+	"find":        {2, 2},
+	"any":         {1, 2},
+	"all":         {1, 2},
+	"sort":        {1, 2},
+	"reverse":     {1, 1},
+	"zip":         {1, variadic},
+	"range":       {1, 3},
+	"flatten":     {1, 2},
+	"uniq":        {1, 1},
+	"slice":       {2, 3},
+	"index_of":    {2, 2},
+	"join":        {1, 2},
+	"split":       {1, 2},
+	"trim":        {1, 2},
+	"upper":       {1, 1},
+	"lower":       {1, 1},
+	"replace":     {3, 4},
+	"contains":    {2, 2},
+	"starts_with": {2, 2},
+	"ends_with":   {2, 2},
+	"index":       {2, 2},
+	"substr":      {2, 3},
+	"repeat":      {2, 2},
+	"pad_left":    {2, 3},
+	"pad_right":   {2, 3},
+	"chars":       {1, 1},
+	"format":      {1, variadic},
+}
+
+// arity reports calls of builtins with a number of arguments they do not
+// take.
+func (c *checker) arity(call *ast.CallExpression) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok || ident.Binding.Kind != ast.Builtin {
+		return
+	}
+	arity, ok := arities[ident.Value]
+	if !ok {
+		return
+	}
+	min, max, got := arity[0], arity[1], len(call.Arguments)
+	if got >= min && (max == variadic || got <= max) {
+		return
+	}
+
+	var want string
+	switch {
+	case max == variadic:
+		want = "at least " + arguments(min)
+	case min == max:
+		want = arguments(min)
+	case min+1 == max:
+		want = fmt.Sprintf("%d or %s", min, arguments(max))
+	default:
+		want = fmt.Sprintf("%d to %s", min, arguments(max))
+	}
+	c.report(RuleArity, diagnostic.Error, call, call, fmt.Sprintf("%s takes %s, got %d", ident.Value, want, got))
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// prefix returns the type of a prefix expression with an operand of type
+// right, reporting operators the type does not take.
+func (c *checker) prefix(expr *ast.PrefixExpression, right object.ObjectType) object.ObjectType {
+	if expr.Operator == "!" {
+		return object.BOOLEAN_OBJ
+	}
+	switch right {
+	case "":
+		return ""
+	case object.INTEGER_OBJ, object.FLOAT_OBJ:
+		return right
+	}
+	c.report(RuleTypeMismatch, diagnostic.Error, expr, expr, fmt.Sprintf("unknown operator: %s%s", expr.Operator, right))
+	return ""
+}
+
+// infix returns the type of an infix expression with operands of types
+// left and right, reporting operators the types do not take, as the
+// evaluator would.
+func (c *checker) infix(expr *ast.InfixExpression, left, right object.ObjectType) object.ObjectType {
+	op := expr.Operator
+	if left == "" || right == "" || op == "&&" || op == "||" {
+		return ""
+	}
+
+	comparison := op == "<" || op == ">" || op == "<=" || op == ">="
+	switch {
+	case op == "==" || op == "!=":
+		return object.BOOLEAN_OBJ
+	case isNumber(left) && isNumber(right):
+		if comparison {
+			return object.BOOLEAN_OBJ
+		}
+		if left == object.INTEGER_OBJ && right == object.INTEGER_OBJ {
+			return object.INTEGER_OBJ
+		}
+		return object.FLOAT_OBJ
+	case left != right:
+		c.report(RuleTypeMismatch, diagnostic.Error, expr, expr, fmt.Sprintf("type mismatch: %s %s %s", left, op, right))
+		return ""
+	case left == object.STRING_OBJ && op == "+":
+		return object.STRING_OBJ
+	case left == object.STRING_OBJ && comparison:
+		return object.BOOLEAN_OBJ
+	}
+	c.report(RuleTypeMismatch, diagnostic.Error, expr, expr, fmt.Sprintf("unknown operator: %s %s %s", left, op, right))
+	return ""
+}
+
+func isNumber(t object.ObjectType) bool {
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
+
+// report reports the span from the start of from to the end of to, if the
+// rule is enabled.
+func (c *checker) report(rule string, severity diagnostic.Severity, from, to ast.Node, msg string, hints ...string) {
+	if !c.config.enabled(rule) {
+		return
+	}
+	c.diags = append(c.diags, diagnostic.Diagnostic{
+		Severity: severity,
+		Pos:      from.Pos(),
+		End:      to.End(),
+		Code:     rule,
+		Message:  msg,
+		Hints:    hints,
+	})
+}
@@ -0,0 +1,185 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+// format returns the diagnostics as "line:column severity[code]: message"
+// lines.
+func format(diags []diagnostic.Diagnostic) string {
+	var out strings.Builder
+	for _, d := range diags {
+		fmt.Fprintf(&out, "%s %s[%s]: %s\n", d.Pos, d.Severity, d.Code, d.Message)
+	}
+	return out.String()
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; puts(x)", ""},
+		{"puts(y)", "1:6 error[undefined]: undefined: y\n"},
+		{"fn(a, a) { a }", "1:7 error[duplicate-declaration]: duplicate parameter a\n"},
+		// unused
+		{"let x = 1", "1:5 warning[unused]: x declared and not used\n"},
+		{"let _x = 1", ""},
+		{"let x = 1; x = 2", "1:5 warning[unused]: x declared and not used\n"},
+		{"let x = [1]; x[0] = 2", ""},
+		{"let f = fn() { let a = 1; let b = 2; b }; f()", "1:20 warning[unused]: a declared and not used\n"},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f()", ""},
+		{"let f = fn() { x }; let x = 1; f()", ""},
+		{"let f = fn(a) { let x = 1; fn() { x + a } }; f(1)", ""},
+		{"let x = 1; let x = 2; x", "1:16 warning[duplicate-declaration]: x redeclared in this block\n"},
+		{"for (i, v in [1]) { 1 }", ""},
+		// unreachable
+		{"let f = fn() { return 1; puts(2); puts(3) }; f()", "1:26 warning[unreachable]: unreachable code\n"},
+		{"while (true) { break; 1 }\nwhile (true) { continue; 1 }", "1:23 warning[unreachable]: unreachable code\n2:26 warning[unreachable]: unreachable code\n"},
+		{"let f = fn(x) { if (x) { return 1 } else { return 2 }; 3 }; f(1)", "1:56 warning[unreachable]: unreachable code\n"},
+		{"let f = fn(x) { if (x) { return 1 }; 3 }; f(1)", ""},
+		{"let f = fn() { return 1 }; f()", ""},
+		// arity
+		{"len(1, 2)", "1:1 error[arity]: len takes 1 argument, got 2\n"},
+		{"push([])", "1:1 error[arity]: push takes 2 arguments, got 1\n"},
+		{"exit(1, 2)", "1:1 error[arity]: exit takes 0 or 1 argument, got 2\n"},
+		{"range()", "1:1 error[arity]: range takes 1 to 3 arguments, got 0\n"},
+		{"format()", "1:1 error[arity]: format takes at least 1 argument, got 0\n"},
+		{"puts(); puts(1, 2, 3); range(1, 2, 3)", ""},
+		{"let len = fn() { 0 }; len(1, 2)", ""},
+		{"let f = fn() { len }; f()(1, 2)", ""},
+		// type mismatch
+		{`"a" - 1`, "1:1 error[type-mismatch]: type mismatch: STRING - INTEGER\n"},
+		{`"a" * "b"`, "1:1 error[type-mismatch]: unknown operator: STRING * STRING\n"},
+		{`-"a"`, "1:1 error[type-mismatch]: unknown operator: -STRING\n"},
+		{`-true`, "1:1 error[type-mismatch]: unknown operator: -BOOLEAN\n"},
+		{"true + false", "1:1 error[type-mismatch]: unknown operator: BOOLEAN + BOOLEAN\n"},
+		{"[1] + [2]", "1:1 error[type-mismatch]: unknown operator: ARRAY + ARRAY\n"},
+		{`(1 + 2.5) * "x"`, "1:2 error[type-mismatch]: type mismatch: FLOAT * STRING\n"},
+		{`(1 < 2) + 1`, "1:2 error[type-mismatch]: type mismatch: BOOLEAN + INTEGER\n"},
+		{`("a" - 1) + "b"`, "1:2 error[type-mismatch]: type mismatch: STRING - INTEGER\n"},
+		{`"a" + "b" + "${1}"; "a" < "b"; 1 == "a"; "a" != true; -1.5 % 2; !"a"`, ""},
+		{`1 && "a"; [] || 1`, ""},
+		{`let x = 1; x - "a"; len("a") - "a"`, ""},
+	}
+
+	for _, tt := range tests {
+		got := format(Check(parse(t, tt.input), Config{}))
+		if got != tt.expected {
+			t.Errorf("wrong diagnostics for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestShadow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; let f = fn(x) { x }; f(x)", "1:23 warning[shadow]: declaration of x shadows a variable of an enclosing scope\n"},
+		{"let f = fn() { let x = 2; x }; let x = 1; f(x)", "1:20 warning[shadow]: declaration of x shadows a variable of an enclosing scope\n"},
+		{"let f = fn() { for (args in []) { args } }; f()", "1:21 warning[shadow]: declaration of args shadows a variable of an enclosing scope\n"},
+		{"let len = 1; len", "1:5 warning[shadow]: declaration of len shadows the builtin len\n"},
+		{"let f = fn(first) { first }; f(1)", "1:12 warning[shadow]: declaration of first shadows the builtin first\n"},
+		{"let x = 1; if (true) { let x = 2; x }; x", ""},
+	}
+
+	config := Config{Rules: map[string]bool{RuleShadow: true}, Globals: []string{"args"}}
+	for _, tt := range tests {
+		got := format(Check(parse(t, tt.input), config))
+		if got != tt.expected {
+			t.Errorf("wrong diagnostics for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	input := "let x = 1; let f = fn(x) { return x; 1 }; f(len(y, 2))"
+	tests := []struct {
+		rules    map[string]bool
+		expected []string
+	}{
+		{nil, []string{RuleUnused, RuleUnreachable, RuleArity, RuleUndefined}},
+		{map[string]bool{RuleShadow: true, RuleUnused: false}, []string{RuleShadow, RuleUnreachable, RuleArity, RuleUndefined}},
+		{map[string]bool{RuleUndefined: false, RuleArity: false, RuleUnreachable: false}, []string{RuleUnused}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, d := range Check(parse(t, input), Config{Rules: tt.rules}) {
+			got = append(got, d.Code)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("wrong rules reported with %v. want=%v, got=%v", tt.rules, tt.expected, got)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	program := parse(t, "puts(args)")
+	if diags := Check(program, Config{Globals: []string{"args"}}); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %s", format(diags))
+	}
+}
+
+func TestRules(t *testing.T) {
+	for _, rule := range Rules {
+		if got, ok := LookupRule(rule.Name); !ok || got != rule {
+			t.Errorf("rule %s not found", rule.Name)
+		}
+	}
+	if _, ok := LookupRule("nope"); ok {
+		t.Errorf("unknown rule found")
+	}
+}
+
+// TestArities checks the arities against the builtins, which must reject
+// one argument less and one more than the arity allows.
+func TestArities(t *testing.T) {
+	if len(arities) != len(object.Builtins) {
+		t.Errorf("wrong number of arities. want=%d, got=%d", len(object.Builtins), len(arities))
+	}
+
+	for _, def := range object.Builtins {
+		arity, ok := arities[def.Name]
+		if !ok {
+			t.Errorf("no arity for %s", def.Name)
+			continue
+		}
+		counts := []int{}
+		if arity[0] > 0 {
+			counts = append(counts, arity[0]-1)
+		}
+		if arity[1] != variadic {
+			counts = append(counts, arity[1]+1)
+		}
+		for _, n := range counts {
+			args := make([]object.Object, n)
+			for i := range args {
+				args[i] = &object.Null{}
+			}
+			result := def.Builtin.Call(nil, nil, args...)
+			if err, ok := result.(*object.Error); !ok || !strings.Contains(err.Message, "wrong number of arguments") {
+				t.Errorf("%s with %d arguments did not fail with wrong number of arguments. got=%v", def.Name, n, result)
+			}
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first character belonging to the node
+	End() token.Position // position immediately after the node
 }
 
 type Statement interface {
@@ -24,6 +26,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []*CommentGroup // only set if the lexer emits comments
 }
 
 func NewProgram() *Program {
@@ -46,8 +49,23 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 type LetStatement struct {
-	Token token.Token // the token.LET token
+	Token token.Token   // the token.LET token
+	Doc   *CommentGroup // comments directly above the statement, or nil
 	Name  *Identifier
 	Value Expression
 }
@@ -71,13 +89,41 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	return ls.Token.End
+}
+
 func (ls *LetStatement) statementNode() {}
 
 type Identifier struct {
-	Token token.Token // the token.IDENT token
-	Value string
+	Token   token.Token // the token.IDENT token
+	Value   string
+	Binding Binding // set by the resolver
 }
 
+// Binding tells where the variable an Identifier refers to is stored.
+type Binding struct {
+	Kind  BindingKind
+	Depth int // for Variable: number of enclosing function scopes to go out of
+	Slot  int // for Variable: index of the variable in its scope
+}
+
+type BindingKind int
+
+const (
+	Unresolved BindingKind = iota // looked up by name at runtime
+	Variable                      // stored in the slot of a scope
+	Builtin                       // a builtin function
+)
+
 func (i *Identifier) TokenLiteral() string {
 	return i.Token.Literal
 }
@@ -86,6 +132,10 @@ func (i *Identifier) String() string {
 	return i.Value
 }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
+func (i *Identifier) End() token.Position { return i.Token.End }
+
 func (i *Identifier) statementNode()  {}
 func (i *Identifier) expressionNode() {}
 
@@ -110,6 +160,15 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
+
 func (rs *ReturnStatement) statementNode() {}
 
 type ExpressionStatement struct {
@@ -129,6 +188,20 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
+
 func (es *ExpressionStatement) statementNode() {}
 
 type IntegerLiteral struct {
@@ -144,8 +217,31 @@ func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+func (il *IntegerLiteral) Pos() token.Position { return il.Token.Pos }
+
+func (il *IntegerLiteral) End() token.Position { return il.Token.End }
+
 func (il *IntegerLiteral) expressionNode() {}
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FloatLiteral) End() token.Position { return fl.Token.End }
+
+func (fl *FloatLiteral) expressionNode() {}
+
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
 	Operator string
@@ -167,6 +263,15 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
+
 func (pe *PrefixExpression) expressionNode() {}
 
 type InfixExpression struct {
@@ -192,6 +297,20 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
+
 func (ie *InfixExpression) expressionNode() {}
 
 type Boolean struct {
@@ -207,6 +326,10 @@ func (b *Boolean) String() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
+func (b *Boolean) End() token.Position { return b.Token.End }
+
 func (b *Boolean) expressionNode() {}
 
 type IfExpression struct {
@@ -225,7 +348,7 @@ func (ie *IfExpression) String() string {
 
 	out.WriteString("if")
 	out.WriteString(" " + ie.Condition.String() + " ")
-	out.WriteString(ie.Condition.String())
+	out.WriteString(ie.Consequence.String())
 	if ie.Alternative != nil {
 		out.WriteString("else ")
 		out.WriteString(ie.Alternative.String())
@@ -234,11 +357,24 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
+
 func (ie *IfExpression) expressionNode() {}
 
 type BlockStatement struct {
 	Token      token.Token // The '{' token
 	Statements []Statement
+	Rbrace     token.Token // The '}' token
 }
 
 func (bs *BlockStatement) TokenLiteral() string {
@@ -255,14 +391,20 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BlockStatement) End() token.Position { return bs.Rbrace.End }
+
 func (bs *BlockStatement) statementNode() {}
 
 // ============================================================================
 // function literal
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
+	Name       string      // name of the let binding, if the literal is bound directly
 	Parameters []*Identifier
 	Body       *BlockStatement
+	NumLocals  int // variables of the function scope, parameters first; set by the resolver
 }
 
 func (fl *FunctionLiteral) expressionNode() {
@@ -272,6 +414,15 @@ func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -295,12 +446,17 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Token // The ')' token
 }
 
 func (ce *CallExpression) expressionNode() {}
 
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+func (ce *CallExpression) Pos() token.Position { return ce.Function.Pos() }
+
+func (ce *CallExpression) End() token.Position { return ce.Rparen.End }
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -327,19 +483,56 @@ func (sl *StringLiteral) expressionNode() {}
 
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 
+func (sl *StringLiteral) Pos() token.Position { return sl.Token.Pos }
+
+func (sl *StringLiteral) End() token.Position { return sl.Token.End }
+
 func (sl *StringLiteral) String() string { return sl.Token.Literal }
 
+// ============================================================================
+// Interpolated string, "a ${x} b"
+type InterpolatedString struct {
+	Token token.Token  // the token.INTERP_START token
+	Parts []Expression // *StringLiteral for the text, any expression for ${}
+	Close token.Token  // the token.INTERP_END token
+}
+
+func (is *InterpolatedString) expressionNode() {}
+
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+
+func (is *InterpolatedString) Pos() token.Position { return is.Token.Pos }
+
+func (is *InterpolatedString) End() token.Position { return is.Close.End }
+
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+	for _, part := range is.Parts {
+		if s, ok := part.(*StringLiteral); ok {
+			out.WriteString(s.Value)
+			continue
+		}
+		out.WriteString("${" + part.String() + "}")
+	}
+	return out.String()
+}
+
 // ============================================================================
 // Array literal
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // the '[' token
 	Elements []Expression
+	Rbracket token.Token // the ']' token
 }
 
 func (al *ArrayLiteral) expressionNode() {}
 
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
+func (al *ArrayLiteral) End() token.Position { return al.Rbracket.End }
+
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -358,15 +551,20 @@ func (al *ArrayLiteral) String() string {
 // ============================================================================
 // Index Expression
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // the '[' token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // the ']' token
 }
 
 func (ie *IndexExpression) expressionNode() {}
 
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *IndexExpression) Pos() token.Position { return ie.Left.Pos() }
+
+func (ie *IndexExpression) End() token.Position { return ie.Rbracket.End }
+
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -379,23 +577,70 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// ============================================================================
+// Assign Expression
+//
+// x = v, x += v, arr[i] = v, ...
+type AssignExpression struct {
+	Token    token.Token // the assignment operator token, e.g. = or +=
+	Target   Expression  // *Identifier or *IndexExpression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+
+func (ae *AssignExpression) Pos() token.Position {
+	if ae.Target != nil {
+		return ae.Target.Pos()
+	}
+	return ae.Token.Pos
+}
+
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 // ============================================================================
 // Hash Literal
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token  token.Token // the '{' token
+	Pairs  map[Expression]Expression
+	Keys   []Expression // keys of Pairs in source order
+	Rbrace token.Token  // the '}' token
 }
 
 func (hl *HashLiteral) expressionNode() {}
 
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
+func (hl *HashLiteral) End() token.Position { return hl.Rbrace.End }
+
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	var pairs []string
 
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -404,3 +649,154 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// ============================================================================
+// While Statement
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Pos }
+
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while ")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// ============================================================================
+// For Statement
+//
+// for (value in iterable) { ... } or for (key, value in iterable) { ... }
+type ForStatement struct {
+	Token    token.Token // the 'for' token
+	Key      *Identifier // index or hash key, nil if only one variable is given
+	Value    *Identifier // element, character or hash key (value, if Key is set)
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+
+func (fs *ForStatement) Pos() token.Position { return fs.Token.Pos }
+
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String() + ", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// ============================================================================
+// Break Statement
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BreakStatement) End() token.Position { return bs.Token.End }
+
+func (bs *BreakStatement) String() string { return bs.Token.Literal + ";" }
+
+// ============================================================================
+// Continue Statement
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
+func (cs *ContinueStatement) End() token.Position { return cs.Token.End }
+
+func (cs *ContinueStatement) String() string { return cs.Token.Literal + ";" }
+
+// ============================================================================
+// Comments
+
+// Comment is a // line comment or a /* block */ comment.
+type Comment struct {
+	Token token.Token // the token.COMMENT token
+}
+
+func (c *Comment) Pos() token.Position { return c.Token.Pos }
+
+func (c *Comment) End() token.Position { return c.Token.End }
+
+// Text returns the comment without its delimiters.
+func (c *Comment) Text() string {
+	text := c.Token.Literal
+	if strings.HasPrefix(text, "//") {
+		return text[2:]
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+}
+
+// CommentGroup is a run of comments with no code and no blank line between
+// them.
+type CommentGroup struct {
+	List []*Comment
+}
+
+func (g *CommentGroup) Pos() token.Position { return g.List[0].Pos() }
+
+func (g *CommentGroup) End() token.Position { return g.List[len(g.List)-1].End() }
+
+// Text returns the text of the comments, one per line, with the leading
+// space of line comments and the surrounding space of block comments
+// removed.
+func (g *CommentGroup) Text() string {
+	var lines []string
+	for _, c := range g.List {
+		if strings.HasPrefix(c.Token.Literal, "//") {
+			lines = append(lines, strings.TrimPrefix(c.Text(), " "))
+		} else {
+			lines = append(lines, strings.TrimSpace(c.Text()))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
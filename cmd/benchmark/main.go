@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/startdusk/tinyscript/compiler"
+	"github.com/startdusk/tinyscript/evaluator"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/resolver"
+	"github.com/startdusk/tinyscript/vm"
+)
+
+var (
+	engine = flag.String("engine", "vm", "use 'eval' or 'vm'")
+	n      = flag.Int("n", 30, "compute fibonacci(n)")
+)
+
+const input = `
+let fibonacci = fn(x) {
+	if (x == 0) {
+		0
+	} else {
+		if (x == 1) {
+			return 1;
+		} else {
+			fibonacci(x - 1) + fibonacci(x - 2);
+		}
+	}
+};
+fibonacci(%d);
+`
+
+func main() {
+	flag.Parse()
+
+	l := lexer.New(fmt.Sprintf(input, *n))
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	var duration time.Duration
+	var result object.Object
+
+	switch *engine {
+	case "vm":
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			fmt.Printf("compiler error: %s\n", err)
+			os.Exit(1)
+		}
+
+		machine := vm.New(comp.Bytecode())
+
+		start := time.Now()
+		if err := machine.Run(); err != nil {
+			fmt.Printf("vm error: %s\n", err)
+			os.Exit(1)
+		}
+		duration = time.Since(start)
+		result = machine.LastPoppedStackElem()
+	case "eval":
+		env := object.NewEnvironment()
+		resolver.Resolve(program, env, func(name string) bool { return object.GetBuiltinByName(name) != nil })
+		start := time.Now()
+		result = evaluator.Eval(program, env)
+		duration = time.Since(start)
+	default:
+		fmt.Printf("unknown engine %q\n", *engine)
+		os.Exit(2)
+	}
+
+	fmt.Printf("engine=%s, result=%s, duration=%s\n", *engine, result.Inspect(), duration)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinyscript/analysis"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/parser"
+)
+
+const checkUsage = `Usage:
+
+	tinyscript check [-enable rules] [-disable rules] [-rules] [files...]
+
+Check reports likely mistakes in the files, or in the script on stdin without
+files, without running them. -enable and -disable turn on or off the rules in
+a comma-separated list, and -rules lists the rules. The exit status is 1 if
+anything is reported.
+`
+
+func runCheck(arguments []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, checkUsage) }
+	enable := flags.String("enable", "", "turn on the `rules`")
+	disable := flags.String("disable", "", "turn off the `rules`")
+	list := flags.Bool("rules", false, "list the rules")
+	if err := flags.Parse(arguments); err != nil {
+		return exitUsage
+	}
+
+	config := analysis.Config{Rules: make(map[string]bool), Globals: []string{"args"}}
+	for _, setting := range []struct {
+		rules string
+		on    bool
+	}{{*enable, true}, {*disable, false}} {
+		for _, name := range strings.Split(setting.rules, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if _, ok := analysis.LookupRule(name); !ok {
+				fmt.Fprintf(os.Stderr, "tinyscript check: unknown rule %q, see -rules\n", name)
+				return exitUsage
+			}
+			config.Rules[name] = setting.on
+		}
+	}
+
+	if *list {
+		for _, rule := range analysis.Rules {
+			state := "off"
+			if on, ok := config.Rules[rule.Name]; on || !ok && rule.Default {
+				state = "on"
+			}
+			fmt.Fprintf(os.Stdout, "%-22s %-3s %s\n", rule.Name, state, rule.Doc)
+		}
+		return exitOK
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tinyscript: %s\n", err)
+			return exitError
+		}
+		return check(os.Stderr, "<stdin>", string(src), config)
+	}
+
+	exit := exitOK
+	for _, filename := range flags.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tinyscript: %s\n", err)
+			exit = exitError
+			continue
+		}
+		if code := check(os.Stderr, filename, string(src), config); code != exitOK {
+			exit = code
+		}
+	}
+	return exit
+}
+
+// check reports the syntax errors of src, or what analysis finds in it, on
+// w.
+func check(w io.Writer, filename, src string, config analysis.Config) int {
+	p := parser.New(lexer.New(src, lexer.WithFilename(filename)))
+	program := p.ParseProgram()
+	diags := p.Diagnostics()
+	if len(diags) == 0 {
+		diags = analysis.Check(program, config)
+	}
+	diagnostic.RenderAll(w, src, diags)
+	if len(diags) != 0 {
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/format"
+)
+
+const fmtUsage = `Usage:
+
+	tinyscript fmt [-w] [-d] [files...]
+
+Fmt prints the files formatted, or the script on stdin without files.
+-w writes the formatted code back to the files that are not formatted, and
+-d prints the changes formatting makes as a diff instead.
+`
+
+// formatter runs the fmt command.
+type formatter struct {
+	write  bool
+	diff   bool
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func runFmt(arguments []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, fmtUsage) }
+	f := &formatter{stdout: os.Stdout, stderr: os.Stderr}
+	flags.BoolVar(&f.write, "w", false, "write the result to the files")
+	flags.BoolVar(&f.diff, "d", false, "print diffs instead of the formatted code")
+	if err := flags.Parse(arguments); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		if f.write {
+			fmt.Fprintln(os.Stderr, "tinyscript fmt: cannot use -w with stdin")
+			return exitUsage
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(f.stderr, "tinyscript: %s\n", err)
+			return exitError
+		}
+		return f.format("<stdin>", src)
+	}
+
+	exit := exitOK
+	for _, filename := range flags.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(f.stderr, "tinyscript: %s\n", err)
+			exit = exitError
+			continue
+		}
+		if code := f.format(filename, src); code != exitOK {
+			exit = code
+		}
+	}
+	return exit
+}
+
+// format formats src, read from filename, and writes it back or prints it
+// or the diff.
+func (f *formatter) format(filename string, src []byte) int {
+	out, err := format.Source(src)
+	var syntaxErr *format.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		diags := syntaxErr.Diagnostics
+		for i := range diags {
+			diags[i].Pos.Filename, diags[i].End.Filename = filename, filename
+		}
+		diagnostic.RenderAll(f.stderr, string(src), diags)
+		return exitError
+	}
+
+	switch {
+	case f.diff:
+		if !bytes.Equal(src, out) {
+			writeDiff(f.stdout, filename, string(src), string(out))
+		}
+	case f.write:
+		if bytes.Equal(src, out) {
+			return exitOK
+		}
+		info, err := os.Stat(filename)
+		if err == nil {
+			err = os.WriteFile(filename, out, info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintf(f.stderr, "tinyscript: %s\n", err)
+			return exitError
+		}
+	default:
+		f.stdout.Write(out)
+	}
+	return exitOK
+}
+
+// diffContext is the number of unchanged lines shown around changes.
+const diffContext = 3
+
+// writeDiff writes the changes from a to b as a unified diff of their
+// lines.
+func writeDiff(w io.Writer, filename, a, b string) {
+	x, y := splitLines(a), splitLines(b)
+
+	// the lines before the first and after the last change are left out of
+	// the comparison, see lineEdits
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	// edits holds a line per line of x and y, prefixed by ' ', '-' or '+'.
+	var edits []string
+	for _, line := range x[:prefix] {
+		edits = append(edits, " "+line)
+	}
+	edits = append(edits, lineEdits(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, line := range x[len(x)-suffix:] {
+		edits = append(edits, " "+line)
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s (formatted)\n", filename, filename)
+	line := [2]int{1, 1} // lines of x and y at edits[start]
+	for start := 0; start < len(edits); {
+		first := start
+		for first < len(edits) && edits[first][0] == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		// the hunk runs from diffContext lines before the first change to
+		// diffContext lines after the last change not followed by more
+		// than 2*diffContext unchanged lines
+		begin := max(start, first-diffContext)
+		end, unchanged := first, 0
+		for k := first; k < len(edits) && unchanged <= 2*diffContext; k++ {
+			if edits[k][0] == ' ' {
+				unchanged++
+			} else {
+				end, unchanged = k+1, 0
+			}
+		}
+		end = min(len(edits), end+diffContext)
+
+		line[0], line[1] = line[0]+begin-start, line[1]+begin-start
+		var hunk strings.Builder
+		count := [2]int{}
+		for _, e := range edits[begin:end] {
+			hunk.WriteString(e + "\n")
+			if e[0] != '+' {
+				count[0]++
+			}
+			if e[0] != '-' {
+				count[1]++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n%s", hunkRange(line[0], count[0]), hunkRange(line[1], count[1]), hunk.String())
+		line[0], line[1] = line[0]+count[0], line[1]+count[1]
+		start = end
+	}
+}
+
+// maxDiffTable bounds the size of the table lineEdits compares lines with,
+// which is quadratic in their number.
+const maxDiffTable = 1 << 22
+
+// lineEdits returns the edits turning x into y, keeping a longest common
+// subsequence of their lines. If there are too many lines to compare, all
+// lines of x are removed and all lines of y added instead.
+func lineEdits(x, y []string) []string {
+	if (len(x)+1)*(len(y)+1) > maxDiffTable {
+		edits := make([]string, 0, len(x)+len(y))
+		for _, line := range x {
+			edits = append(edits, "-"+line)
+		}
+		for _, line := range y {
+			edits = append(edits, "+"+line)
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var edits []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, " "+x[i])
+			i, j = i+1, j+1
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, "-"+x[i])
+			i++
+		default:
+			edits = append(edits, "+"+y[j])
+			j++
+		}
+	}
+	return edits
+}
+
+// hunkRange formats the lines of a hunk in one of the files, where an
+// empty range starts at the line before it.
+func hunkRange(line, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,23 +1,149 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"os/user"
+	"path/filepath"
 
 	"github.com/startdusk/tinyscript/repl"
 )
 
+const usage = `Usage:
+
+	tinyscript [-engine=eval|vm] [-q] <command> [arguments]
+
+The commands are:
+
+	run <file> [args...]     run a script file, "-" reads it from stdin
+	eval -e <code> [args...] evaluate code given on the command line
+	repl                     start the interactive REPL, -q leaves out the banner
+	fmt [-w] [-d] [files...] format scripts, see "tinyscript fmt -h"
+	check [files...]         report likely mistakes, see "tinyscript check -h"
+
+"tinyscript <file>" is short for "tinyscript run <file>" and "tinyscript -e <code>"
+for "tinyscript eval -e <code>". Without a command the REPL is started, or the
+script piped to stdin is run. Script arguments are available in the args array.
+`
+
+const (
+	exitOK    = 0
+	exitError = 1 // the script failed to parse or raised a runtime error
+	exitUsage = 2
+)
+
 func main() {
-	user, err := user.Current()
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(arguments []string) int {
+	flags := flag.NewFlagSet("tinyscript", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	engine := flags.String("engine", "eval", "use 'eval' or 'vm'")
+	code := flags.String("e", "", "evaluate `code` instead of a script file")
+	quiet := flags.Bool("q", false, "start the REPL without the banner")
+	if err := flags.Parse(arguments); err != nil {
+		return exitUsage
+	}
+	if *engine != string(repl.EngineEval) && *engine != string(repl.EngineVM) {
+		fmt.Fprintf(os.Stderr, "tinyscript: unknown engine %q\n", *engine)
+		return exitUsage
+	}
+	r := &runner{engine: repl.Engine(*engine), stdout: os.Stdout, stderr: os.Stderr}
+
+	if *code != "" {
+		return r.evalCode(*code, flags.Args())
+	}
+
+	args := flags.Args()
+	if len(args) == 0 {
+		if stdinIsTerminal() {
+			return startRepl(r.engine, *quiet)
+		}
+		return r.runStdin(nil)
+	}
+
+	switch args[0] {
+	case "run":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "tinyscript run: missing script file")
+			return exitUsage
+		}
+		if args[1] == "-" {
+			return r.runStdin(args[2:])
+		}
+		return r.runFile(args[1], args[2:])
+	case "eval":
+		evalFlags := flag.NewFlagSet("eval", flag.ContinueOnError)
+		evalCode := evalFlags.String("e", "", "`code` to evaluate")
+		if err := evalFlags.Parse(args[1:]); err != nil {
+			return exitUsage
+		}
+		if *evalCode == "" {
+			fmt.Fprintln(os.Stderr, "tinyscript eval: missing -e <code>")
+			return exitUsage
+		}
+		return r.evalCode(*evalCode, evalFlags.Args())
+	case "fmt":
+		return runFmt(args[1:])
+	case "check":
+		return runCheck(args[1:])
+	case "repl":
+		return startRepl(r.engine, *quiet)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	default:
+		return r.runFile(args[0], args[1:])
+	}
+}
+
+func startRepl(engine repl.Engine, quiet bool) int {
+	repl.StartWithOptions(os.Stdin, os.Stdout, repl.Options{Engine: engine, HistoryFile: historyFile(), Quiet: quiet})
+	return exitOK
+}
+
+// historyFile returns the file keeping the REPL history, which is
+// $TINYSCRIPT_HISTORY if set, and ~/.tinyscript_history otherwise. An empty
+// $TINYSCRIPT_HISTORY disables the history file.
+func historyFile() string {
+	if file, ok := os.LookupEnv("TINYSCRIPT_HISTORY"); ok {
+		return file
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		panic(err)
+		return ""
 	}
+	return filepath.Join(home, ".tinyscript_history")
+}
+
+func (r *runner) runFile(filename string, args []string) int {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "tinyscript: %s\n", err)
+		return exitError
+	}
+	return r.execute(filename, string(src), args, false)
+}
 
-	fmt.Printf(`
-Hello %s! This is the Typescript programing language!
-Feel free to type in command
-`,
-		user.Username)
-	repl.Start(os.Stdin, os.Stdout)
+func (r *runner) runStdin(args []string) int {
+	src, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(r.stderr, "tinyscript: %s\n", err)
+		return exitError
+	}
+	return r.execute("<stdin>", string(src), args, false)
+}
+
+func (r *runner) evalCode(code string, args []string) int {
+	return r.execute("<eval>", code, args, true)
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
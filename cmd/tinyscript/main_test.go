@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs the command line arguments with stdin as the standard
+// input, and returns the exit code and what was written to the standard
+// output and error.
+func runCommand(t *testing.T, stdin string, arguments ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 3)
+	for i, name := range []string{"stdin", "stdout", "stderr"} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	if _, err := files[0].WriteString(stdin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files[0].Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	oldStdin, oldStdout, oldStderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = files[0], files[1], files[2]
+	defer func() { os.Stdin, os.Stdout, os.Stderr = oldStdin, oldStdout, oldStderr }()
+	code = run(arguments)
+
+	out, err := os.ReadFile(files[1].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errOut, err := os.ReadFile(files[2].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(out), string(errOut)
+}
+
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "script.ts")
+	if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestRun(t *testing.T) {
+	script := writeScript(t, "puts(len(args)); puts(args)")
+	tests := []struct {
+		stdin          string
+		arguments      []string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string // contained in the standard error
+	}{
+		{"", []string{"-e", "1 + 2"}, exitOK, "3\n", ""},
+		{"", []string{"-engine", "vm", "-e", "1 + 2"}, exitOK, "3\n", ""},
+		{"", []string{"eval", "-e", "puts(args)", "a", "b"}, exitOK, "[a, b]\n", ""},
+		{"", []string{"-e", "puts(args); args[0]", "a"}, exitOK, "[a]\na\n", ""},
+		{"", []string{"run", script, "x", "y"}, exitOK, "2\n[x, y]\n", ""},
+		{"", []string{script}, exitOK, "0\n[]\n", ""},
+		{"puts(args)", []string{"run", "-", "z"}, exitOK, "[z]\n", ""},
+		{"puts(1 + 1)", nil, exitOK, "2\n", ""},
+		{"", []string{"-e", "exit(3)"}, 3, "", ""},
+		{"", []string{"-engine", "vm", "-e", "exit(4)"}, 4, "", ""},
+		{"", []string{"-e", "x"}, exitError, "", "undefined: x"},
+		{"", []string{"-e", "let"}, exitError, "", "error"},
+		{"", []string{"-e", "1 / 0"}, exitError, "", "division by zero"},
+		{"", []string{"-engine", "vm", "-e", "1 / 0"}, exitError, "", "division by zero"},
+		{"", []string{"run", "missing.ts"}, exitError, "", "missing.ts"},
+		{"", []string{"run"}, exitUsage, "", "missing script file"},
+		{"", []string{"eval"}, exitUsage, "", "missing -e <code>"},
+		{"", []string{"-engine", "js", "-e", "1"}, exitUsage, "", `unknown engine "js"`},
+		{"", []string{"-nope"}, exitUsage, "", "Usage:"},
+		{"", []string{"help"}, exitOK, usage, ""},
+	}
+
+	for _, tt := range tests {
+		code, stdout, stderr := runCommand(t, tt.stdin, tt.arguments...)
+		if code != tt.expectedCode {
+			t.Errorf("wrong exit code for %q. want=%d, got=%d (stderr %q)", tt.arguments, tt.expectedCode, code, stderr)
+		}
+		if stdout != tt.expectedStdout {
+			t.Errorf("wrong stdout for %q. want=%q, got=%q", tt.arguments, tt.expectedStdout, stdout)
+		}
+		if !strings.Contains(stderr, tt.expectedStderr) {
+			t.Errorf("wrong stderr for %q. want it to contain %q, got=%q", tt.arguments, tt.expectedStderr, stderr)
+		}
+	}
+}
+
+// TestRunResult checks that both engines print the value of the last
+// statement only if it has one.
+func TestRunResult(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"let x = 5", ""},
+		{"let x = 5; x", "5\n"},
+		{"1; let x = 5", ""},
+		{"1; while (false) { 2 }", ""},
+		{"fn(x) { x }", "fn(x) {\nx\n}\n"},
+		{"let i = 0; while (i < 2) { i += 1 }", ""},
+		{"for (x in [1, 2]) { x }", ""},
+		{"let f = fn() { 1 }; f()", "1\n"},
+		{"puts(1)", "1\n"},
+		{"[fn() {}()]", "[null]\n"},
+		{"let f = fn() {}; puts([f()])", "[null]\n"},
+	}
+
+	for _, engine := range []string{"eval", "vm"} {
+		for _, tt := range tests {
+			code, stdout, stderr := runCommand(t, "", "-engine", engine, "-e", tt.code)
+			if code != exitOK {
+				t.Errorf("%s: %q failed with %d: %s", engine, tt.code, code, stderr)
+			}
+			if stdout != tt.expected {
+				t.Errorf("%s: wrong output for %q. want=%q, got=%q", engine, tt.code, tt.expected, stdout)
+			}
+		}
+	}
+}
+
+func TestFmt(t *testing.T) {
+	const (
+		unformatted = "let x=1\nputs( x )\n"
+		formatted   = "let x = 1;\nputs(x);\n"
+	)
+
+	code, stdout, _ := runCommand(t, unformatted, "fmt")
+	if code != exitOK || stdout != formatted {
+		t.Errorf("fmt of stdin failed. code=%d, stdout=%q", code, stdout)
+	}
+
+	script := writeScript(t, unformatted)
+	code, stdout, _ = runCommand(t, "", "fmt", "-d", script)
+	expectedDiff := "--- " + script + "\n+++ " + script + " (formatted)\n" +
+		"@@ -1,2 +1,2 @@\n-let x=1\n-puts( x )\n+let x = 1;\n+puts(x);\n"
+	if code != exitOK || stdout != expectedDiff {
+		t.Errorf("fmt -d failed. code=%d, stdout=%q", code, stdout)
+	}
+
+	code, stdout, _ = runCommand(t, "", "fmt", "-w", script)
+	if code != exitOK || stdout != "" {
+		t.Errorf("fmt -w failed. code=%d, stdout=%q", code, stdout)
+	}
+	if src, err := os.ReadFile(script); err != nil || string(src) != formatted {
+		t.Errorf("fmt -w did not write the formatted script. got=%q, %v", src, err)
+	}
+	code, stdout, _ = runCommand(t, "", "fmt", "-d", script)
+	if code != exitOK || stdout != "" {
+		t.Errorf("fmt -d of a formatted script printed a diff. code=%d, stdout=%q", code, stdout)
+	}
+
+	if code, _, stderr := runCommand(t, unformatted, "fmt", "-w"); code != exitUsage || !strings.Contains(stderr, "cannot use -w with stdin") {
+		t.Errorf("fmt -w of stdin did not fail. code=%d, stderr=%q", code, stderr)
+	}
+	if code, _, stderr := runCommand(t, "let x = ", "fmt"); code != exitError || !strings.Contains(stderr, "expected an expression") {
+		t.Errorf("fmt of a syntax error did not fail. code=%d, stderr=%q", code, stderr)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		stdin          string
+		arguments      []string
+		expectedCode   int
+		expectedStderr string // contained in the standard error, empty if none
+	}{
+		{"let x = 1; puts(x, args)", nil, exitOK, ""},
+		{"let x = 1", nil, exitError, "warning[unused]: x declared and not used"},
+		{"let x = 1", []string{"-disable", "unused"}, exitOK, ""},
+		{"puts(y)", nil, exitError, "error[undefined]: undefined: y"},
+		{"let x = ", nil, exitError, "expected an expression"},
+		{"", []string{"-enable", "nope"}, exitUsage, `unknown rule "nope"`},
+	}
+
+	for _, tt := range tests {
+		code, _, stderr := runCommand(t, tt.stdin, append([]string{"check"}, tt.arguments...)...)
+		if code != tt.expectedCode {
+			t.Errorf("wrong exit code for %q %q. want=%d, got=%d", tt.stdin, tt.arguments, tt.expectedCode, code)
+		}
+		if tt.expectedStderr == "" && stderr != "" || !strings.Contains(stderr, tt.expectedStderr) {
+			t.Errorf("wrong stderr for %q %q. want=%q, got=%q", tt.stdin, tt.arguments, tt.expectedStderr, stderr)
+		}
+	}
+
+	code, stdout, _ := runCommand(t, "", "check", "-rules")
+	if code != exitOK || !strings.Contains(stdout, "unused") {
+		t.Errorf("check -rules failed. code=%d, stdout=%q", code, stdout)
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	lines := func(from, to int, changed ...int) string {
+		var out strings.Builder
+		for i := from; i < to; i++ {
+			line := fmt.Sprintf("line %d", i)
+			for _, c := range changed {
+				if i == c {
+					line += " changed"
+				}
+			}
+			out.WriteString(line + "\n")
+		}
+		return out.String()
+	}
+	var all []int
+	var changedAll strings.Builder
+	changedAll.WriteString("@@ -1,25000 +1,25000 @@\n")
+	for i := 0; i < 25000; i++ {
+		all = append(all, i)
+		fmt.Fprintf(&changedAll, "-line %d\n", i)
+	}
+	for i := 0; i < 25000; i++ {
+		fmt.Fprintf(&changedAll, "+line %d changed\n", i)
+	}
+	tests := []struct {
+		a, b     string
+		expected string
+	}{
+		{
+			lines(0, 10), lines(0, 10, 5),
+			"@@ -3,7 +3,7 @@\n line 2\n line 3\n line 4\n-line 5\n+line 5 changed\n line 6\n line 7\n line 8\n",
+		},
+		{
+			lines(0, 3), lines(0, 4),
+			"@@ -1,3 +1,4 @@\n line 0\n line 1\n line 2\n+line 3\n",
+		},
+		{
+			lines(0, 20), lines(0, 20, 1, 18),
+			"@@ -1,5 +1,5 @@\n line 0\n-line 1\n+line 1 changed\n line 2\n line 3\n line 4\n" +
+				"@@ -16,5 +16,5 @@\n line 15\n line 16\n line 17\n-line 18\n+line 18 changed\n line 19\n",
+		},
+		{
+			// too large for a table of all lines
+			lines(0, 200000), lines(0, 200000, 100000),
+			"@@ -99998,7 +99998,7 @@\n line 99997\n line 99998\n line 99999\n-line 100000\n+line 100000 changed\n line 100001\n line 100002\n line 100003\n",
+		},
+		{
+			// too many changed lines to compare
+			lines(0, 25000), lines(0, 25000, all...),
+			changedAll.String(),
+		},
+	}
+
+	for i, tt := range tests {
+		var out strings.Builder
+		writeDiff(&out, "x.ts", tt.a, tt.b)
+		if want := "--- x.ts\n+++ x.ts (formatted)\n" + tt.expected; out.String() != want {
+			t.Errorf("wrong diff #%d.\nwant=%q\ngot= %q", i, want, out.String())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/startdusk/tinyscript/compiler"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/evaluator"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/repl"
+	"github.com/startdusk/tinyscript/resolver"
+	"github.com/startdusk/tinyscript/vm"
+)
+
+// runner executes whole scripts with the selected engine.
+type runner struct {
+	engine repl.Engine
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// execute runs src and returns the process exit code. Syntax errors,
+// undefined names and runtime errors are reported on stderr. If printResult
+// is set, the value of the last expression is printed unless it is null.
+func (r *runner) execute(filename, src string, args []string, printResult bool) int {
+	l := lexer.New(src, lexer.WithFilename(filename))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		diagnostic.RenderAll(r.stderr, src, p.Diagnostics())
+		return exitError
+	}
+
+	argv := &object.Array{}
+	for _, arg := range args {
+		argv.Elements = append(argv.Elements, &object.String{Value: arg})
+	}
+	env := object.NewEnvironment()
+	env.Set("args", argv)
+	diags := resolver.Resolve(program, env, isBuiltin)
+	diagnostic.RenderAll(r.stderr, src, diags)
+	if diagnostic.HasErrors(diags) {
+		return exitError
+	}
+
+	var result object.Object
+	if r.engine == repl.EngineVM {
+		comp := compiler.New()
+		symbol := comp.SymbolTable().Define("args")
+		if err := comp.Compile(program); err != nil {
+			fmt.Fprintf(r.stderr, "compile error: %s\n", err)
+			return exitError
+		}
+
+		globals := make([]object.Object, vm.GlobalsSize)
+		globals[symbol.Index] = argv
+		machine := vm.NewWithGlobalsState(comp.Bytecode(), globals)
+		if err := machine.Run(); err != nil {
+			result = runtimeError(err)
+		} else {
+			result = machine.LastPoppedStackElem()
+		}
+	} else {
+		result = evaluator.Eval(program, env)
+	}
+
+	if errObj, ok := result.(*object.Error); ok {
+		var exit *object.ExitError
+		if errors.As(errObj, &exit) {
+			return exit.Code
+		}
+		fmt.Fprintln(r.stderr, errObj.StackTrace())
+		return exitError
+	}
+	if printResult && result != nil && result.Type() != object.NULL_OBJ {
+		fmt.Fprintln(r.stdout, result.Inspect())
+	}
+	return exitOK
+}
+
+func isBuiltin(name string) bool {
+	return object.GetBuiltinByName(name) != nil
+}
+
+// runtimeError returns the *object.Error the vm failed with.
+func runtimeError(err error) *object.Error {
+	var errObj *object.Error
+	if errors.As(err, &errObj) {
+		return errObj
+	}
+	return &object.Error{Message: err.Error()}
+}
@@ -0,0 +1,115 @@
+package compiler
+
+import "testing"
+
+func TestDefineResolve(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	firstLocal := NewEnclosedSymbolTable(global)
+	firstLocal.Define("c")
+	firstLocal.Define("d")
+
+	secondLocal := NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("e")
+	secondLocal.Define("f")
+
+	tests := []struct {
+		table           *SymbolTable
+		expectedSymbols []Symbol
+		expectedFree    []Symbol
+	}{
+		{
+			global,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "b", Scope: GlobalScope, Index: 1},
+			},
+			nil,
+		},
+		{
+			firstLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "d", Scope: LocalScope, Index: 1},
+			},
+			nil,
+		},
+		{
+			secondLocal,
+			[]Symbol{
+				{Name: "a", Scope: GlobalScope, Index: 0},
+				{Name: "c", Scope: FreeScope, Index: 0},
+				{Name: "d", Scope: FreeScope, Index: 1},
+				{Name: "e", Scope: LocalScope, Index: 0},
+				{Name: "f", Scope: LocalScope, Index: 1},
+			},
+			[]Symbol{
+				{Name: "c", Scope: LocalScope, Index: 0},
+				{Name: "d", Scope: LocalScope, Index: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, sym := range tt.expectedSymbols {
+			result, ok := tt.table.Resolve(sym.Name)
+			if !ok {
+				t.Errorf("name %s not resolvable", sym.Name)
+				continue
+			}
+			if result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+			}
+		}
+
+		if len(tt.table.FreeSymbols) != len(tt.expectedFree) {
+			t.Errorf("wrong number of free symbols. got=%d, want=%d",
+				len(tt.table.FreeSymbols), len(tt.expectedFree))
+			continue
+		}
+		for i, sym := range tt.expectedFree {
+			if tt.table.FreeSymbols[i] != sym {
+				t.Errorf("wrong free symbol. got=%+v, want=%+v", tt.table.FreeSymbols[i], sym)
+			}
+		}
+	}
+}
+
+func TestRedefineReusesSlot(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	global.Define("b")
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefinition got new symbol. got=%+v, want=%+v", again, a)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("f")
+	f := local.Define("f")
+	if f.Scope != LocalScope || f.Index != 0 {
+		t.Errorf("let shadowing the function name not local. got=%+v", f)
+	}
+
+	names := global.Names()
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("wrong names. got=%q", names)
+	}
+}
+
+func TestResolveBuiltinsAndFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+
+	local := NewEnclosedSymbolTable(NewEnclosedSymbolTable(global))
+	if sym, ok := local.Resolve("len"); !ok || sym != (Symbol{Name: "len", Scope: BuiltinScope, Index: 0}) {
+		t.Errorf("builtin not resolved. got=%+v", sym)
+	}
+
+	local.DefineFunctionName("a")
+	if sym, _ := local.Resolve("a"); sym.Scope != FunctionScope {
+		t.Errorf("function name not resolved. got=%+v", sym)
+	}
+}
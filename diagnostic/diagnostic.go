@@ -0,0 +1,135 @@
+package diagnostic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/startdusk/tinyscript/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Info:
+		return "info"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a message about a span of source code, such as a syntax
+// error reported by the parser.
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position // start of the offending span
+	End      token.Position // position immediately after the offending span
+	Code     string         // short stable identifier, e.g. "unexpected-token"
+	Message  string
+	Hints    []string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// Render writes d to w, followed by the offending source line with a
+// caret underline, e.g.
+//
+//	error[unexpected-token]: expected "=", got "5"
+//	 --> script.ts:1:7
+//	  |
+//	1 | let x 5;
+//	  |       ^
+//	  = hint: ...
+func Render(w io.Writer, src string, d Diagnostic) {
+	if d.Code != "" {
+		fmt.Fprintf(w, "%s[%s]: %s\n", d.Severity, d.Code, d.Message)
+	} else {
+		fmt.Fprintf(w, "%s: %s\n", d.Severity, d.Message)
+	}
+
+	line, ok := sourceLine(src, d.Pos.Line)
+	if !ok {
+		for _, hint := range d.Hints {
+			fmt.Fprintf(w, "  = hint: %s\n", hint)
+		}
+		return
+	}
+
+	num := fmt.Sprintf("%d", d.Pos.Line)
+	gutter := strings.Repeat(" ", len(num))
+	fmt.Fprintf(w, "%s--> %s\n", gutter, d.Pos)
+	fmt.Fprintf(w, "%s |\n", gutter)
+	fmt.Fprintf(w, "%s | %s\n", num, line)
+	fmt.Fprintf(w, "%s | %s\n", gutter, underline(line, d.Pos, d.End))
+	for _, hint := range d.Hints {
+		fmt.Fprintf(w, "%s = hint: %s\n", gutter, hint)
+	}
+}
+
+// HasErrors reports whether any of ds is an error.
+func HasErrors(ds []Diagnostic) bool {
+	for _, d := range ds {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderAll renders every diagnostic in ds, separated by blank lines.
+func RenderAll(w io.Writer, src string, ds []Diagnostic) {
+	for i, d := range ds {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		Render(w, src, d)
+	}
+}
+
+func sourceLine(src string, n int) (string, bool) {
+	if n < 1 {
+		return "", false
+	}
+	lines := strings.Split(src, "\n")
+	if n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// underline returns the padding and carets that mark the span [pos, end)
+// below line. Tabs in the padding are kept so the carets line up.
+func underline(line string, pos, end token.Position) string {
+	var out strings.Builder
+	runes := []rune(line)
+	col := 1
+	for ; col < pos.Column && col-1 < len(runes); col++ {
+		if runes[col-1] == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+
+	width := 1
+	if end.Line == pos.Line && end.Column > pos.Column {
+		width = end.Column - pos.Column
+	} else if end.Line > pos.Line && len(runes) >= pos.Column {
+		width = len(runes) - pos.Column + 1
+	}
+	out.WriteString(strings.Repeat("^", width))
+	return out.String()
+}
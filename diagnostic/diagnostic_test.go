@@ -0,0 +1,67 @@
+package diagnostic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/startdusk/tinyscript/token"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		diag     Diagnostic
+		expected string
+	}{
+		{
+			name: "single_char",
+			src:  "let x 5;",
+			diag: Diagnostic{
+				Severity: Error,
+				Pos:      token.Position{Filename: "a.ts", Line: 1, Column: 7},
+				End:      token.Position{Filename: "a.ts", Line: 1, Column: 8},
+				Code:     "unexpected-token",
+				Message:  `expected "=", got "5"`,
+			},
+			expected: `error[unexpected-token]: expected "=", got "5"
+ --> a.ts:1:7
+  |
+1 | let x 5;
+  |       ^
+`,
+		},
+		{
+			name: "wide_span_with_tabs_and_hints",
+			src:  "let a = 1;\n\tfoo + bar;",
+			diag: Diagnostic{
+				Severity: Warning,
+				Pos:      token.Position{Line: 2, Column: 8},
+				End:      token.Position{Line: 2, Column: 11},
+				Message:  "unused",
+				Hints:    []string{"remove it"},
+			},
+			expected: "warning: unused\n --> 2:8\n  |\n2 | \tfoo + bar;\n  | \t      ^^^\n  = hint: remove it\n",
+		},
+		{
+			name: "no_source",
+			src:  "",
+			diag: Diagnostic{
+				Severity: Error,
+				Pos:      token.Position{Line: 3, Column: 1},
+				Message:  "oops",
+			},
+			expected: "error: oops\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			Render(&out, tt.src, tt.diag)
+			if out.String() != tt.expected {
+				t.Errorf("wrong output.\nexpected=\n%s\ngot=\n%s", tt.expected, out.String())
+			}
+		})
+	}
+}
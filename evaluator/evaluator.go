@@ -1,114 +1,207 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/startdusk/tinyscript/ast"
 	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/token"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// frame is an entry of the evaluator's call stack.
+type frame struct {
+	function string         // name of the called function
+	call     token.Position // position of the call expression in the caller
+}
+
+// Evaluator evaluates AST nodes and keeps track of the call stack, so that
+// runtime errors carry a stack trace.
+type Evaluator struct {
+	frames   []frame
+	builtins map[string]*object.Builtin // nil for object.Builtins
+
+	// resource usage of the current EvalContext or ApplyContext run
+	limits   Limits
+	ctx      context.Context // nil outside of a run
+	steps    int64
+	alloc    int64
+	deadline time.Time
+}
+
+func New() *Evaluator {
+	return &Evaluator{}
+}
+
+// NewWithBuiltins returns an Evaluator that resolves builtin functions in
+// builtins instead of object.Builtins.
+func NewWithBuiltins(builtins map[string]*object.Builtin) *Evaluator {
+	return &Evaluator{builtins: builtins}
+}
+
+// Eval evaluates node in env with a fresh Evaluator. A Go panic during
+// the evaluation, e.g. in a builtin function, results in an error. A
+// program must be resolved in env with resolver.Resolve first.
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	defer recoverPanic(&result)
+	return New().Eval(node, env)
+}
+
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	var obj object.Object
+	if e.ctx != nil {
+		if err := e.step(); err != nil {
+			obj = err
+		}
+	}
+	if obj == nil {
+		obj = e.eval(node, env)
+		if allocates(node) {
+			if err := e.allocated(obj); err != nil {
+				obj = err
+			}
+		}
+	}
+	if err, ok := obj.(*object.Error); ok && err.Trace == nil {
+		err.Trace = e.trace(node.Pos())
+	}
+	return obj
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		return e.evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		// && and || only evaluate the right side if the left one does
+		// not decide the result, which is the deciding operand.
+		switch {
+		case node.Operator == "&&" && !isTruthy(left):
+			return left
+		case node.Operator == "||" && isTruthy(left):
+			return left
+		case node.Operator == "&&" || node.Operator == "||":
+			return e.Eval(node.Right, env)
+		}
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.BlockStatement:
-		return evalBlockStatements(node.Statements, env)
+		return e.evalBlockStatements(node.Statements, env)
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := e.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return e.evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := e.Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		define(node.Name, val, env)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		parameters := node.Parameters
 		body := node.Body
 		return &object.Function{
+			Name:       node.Name,
 			Parameters: parameters,
 			Body:       body,
+			NumLocals:  node.NumLocals,
 			Env:        env,
 		}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := e.Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
+		args := e.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return e.applyFunction(node.Pos(), function, args)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return e.evalInterpolatedString(node, env)
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := e.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := e.Eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.evalHashLiteral(node, env)
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
 	}
 
 	return nil
 }
 
-func evalHashLiteral(
+func (e *Evaluator) evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	hash := &object.Hash{}
+	for _, keyNode := range node.Keys {
+		key := e.Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
@@ -116,20 +209,105 @@ func evalHashLiteral(
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
+		value := e.Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
+}
+
+func (e *Evaluator) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		current, declared := lookup(target, env)
+		if !declared && node.Operator != "=" {
+			return newError("identifier not found: " + target.Value)
+		}
+		val := e.evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+		if !declared {
+			return newError("assignment to undeclared identifier: %s", target.Value)
+		}
+		if val == nil {
+			val = NULL
+		}
+		if target.Binding.Kind == ast.Variable {
+			env.Store(target.Binding.Depth, target.Binding.Slot, val)
+		} else {
+			env.Assign(target.Value, val)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := e.Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := e.Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := e.evalAssignedValue(node, current, env)
+		if isError(val) {
+			return val
+		}
+		if err := evalIndexAssignment(left, index, val); err != nil {
+			return err
+		}
+		return val
+	default:
+		return newError("cannot assign to %s", node.Target.String())
+	}
+}
+
+// evalAssignedValue evaluates the right-hand side of an assignment and, for
+// compound operators like +=, combines it with the current value.
+func (e *Evaluator) evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := e.Eval(node.Value, env)
+	if isError(val) || node.Operator == "=" {
+		return val
+	}
+	operator := strings.TrimSuffix(node.Operator, "=")
+	return evalInfixExpression(operator, current, val)
+}
+
+func evalIndexAssignment(left, index, val object.Object) *object.Error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		array := left.(*object.Array)
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(len(array.Elements)) {
+			return newError("index out of range: %d", idx)
+		}
+		array.Elements[idx] = val
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key, val)
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+	return nil
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -143,11 +321,11 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
-	return pair.Value
+	return value
 }
 
 func evalArrayIndexExpression(left, index object.Object) object.Object {
@@ -161,14 +339,66 @@ func evalArrayIndexExpression(left, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// evalStringIndexExpression returns the rune at index as a string.
+func evalStringIndexExpression(left, index object.Object) object.Object {
+	runes := []rune(left.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+
+	if idx < 0 || idx >= int64(len(runes)) {
+		return NULL
+	}
+	return &object.String{Value: string(runes[idx])}
+}
+
+// Apply calls fn with args on behalf of Go code, as if called from the top
+// level of a script.
+func (e *Evaluator) Apply(fn object.Object, args ...object.Object) object.Object {
+	result := e.applyFunction(token.Position{}, fn, args)
+	if err, ok := result.(*object.Error); ok && err.Trace == nil {
+		err.Trace = e.trace(token.Position{})
+	}
+	return result
+}
+
+// applyFunction calls fn with args from a call expression at call.
+func (e *Evaluator) applyFunction(call token.Position, fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		if len(e.frames) >= e.maxDepth() {
+			return &object.Error{
+				Message: fmt.Sprintf("stack overflow: more than %d nested calls", e.maxDepth()),
+				Err:     ErrLimitExceeded,
+			}
+		}
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		e.frames = append(e.frames, frame{function: name, call: call})
+		defer func() { e.frames = e.frames[:len(e.frames)-1] }()
+
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if evaluated := unwrapReturnValue(e.Eval(fn.Body, extendedEnv)); evaluated != nil {
+			return evaluated
+		}
+		return NULL
 	case *object.Builtin:
-		return fn.Fn(args...)
+		result := fn.Call(func(f object.Object, args ...object.Object) object.Object {
+			if result := e.applyFunction(call, f, args); result != nil {
+				return result
+			}
+			return NULL
+		}, e.reserve, args...)
+		if result == nil {
+			return NULL
+		}
+		if err := e.allocated(result); err != nil {
+			return err
+		}
+		return result
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -182,20 +412,27 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEncloedEnvironment(fn.Env)
+	if fn.NumLocals < len(fn.Parameters) {
+		// not resolved, the parameters are bound by name
+		env := object.NewEncloedEnvironment(fn.Env)
+		for paramIdx, param := range fn.Parameters {
+			env.Set(param.Value, args[paramIdx])
+		}
+		return env
+	}
 
+	env := object.NewFunctionEnvironment(fn.Env, fn.NumLocals)
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		env.Store(0, param.Binding.Slot, args[paramIdx])
 	}
-
 	return env
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var res []object.Object
 
-	for _, e := range exps {
-		evaluated := Eval(e, env)
+	for _, exp := range exps {
+		evaluated := e.Eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -205,39 +442,126 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return res
 }
 
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+func (e *Evaluator) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if val, ok := lookup(node, env); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
-		return builtin
+	if node.Binding.Kind != ast.Variable {
+		if builtin := e.builtin(node.Value); builtin != nil {
+			return builtin
+		}
 	}
 
 	return newError("identifier not found: " + node.Value)
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
+// lookup returns the value of the variable ident refers to, in the slot the
+// resolver bound it to or by name if it is not resolved.
+func lookup(ident *ast.Identifier, env *object.Environment) (object.Object, bool) {
+	switch ident.Binding.Kind {
+	case ast.Variable:
+		val := env.Load(ident.Binding.Depth, ident.Binding.Slot)
+		return val, val != nil
+	case ast.Builtin:
+		return nil, false
+	default:
+		return env.Get(ident.Value)
+	}
+}
+
+// define binds the variable declared by ident to val.
+func define(ident *ast.Identifier, val object.Object, env *object.Environment) {
+	if val == nil {
+		// an empty slot is an unset variable, so nothing is stored as null
+		val = NULL
+	}
+	if ident.Binding.Kind == ast.Variable {
+		env.Store(ident.Binding.Depth, ident.Binding.Slot, val)
+	} else {
+		env.Set(ident.Value, val)
+	}
+}
+
+func (e *Evaluator) builtin(name string) *object.Builtin {
+	if e.builtins == nil {
+		return object.GetBuiltinByName(name)
+	}
+	return e.builtins[name]
+}
+
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := e.Eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return e.Eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.Eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
 }
 
+func (e *Evaluator) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := e.Eval(ws.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		if result, done := loopBody(e.Eval(ws.Body, env)); done {
+			return result
+		}
+	}
+}
+
+func (e *Evaluator) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := e.Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	keys, values, ok := object.Iterate(iterable, fs.Key == nil)
+	if !ok {
+		return newError("not iterable: %s", iterable.Type())
+	}
+	for i := range keys {
+		if fs.Key != nil {
+			define(fs.Key, keys[i], env)
+		}
+		define(fs.Value, values[i], env)
+		if result, done := loopBody(e.Eval(fs.Body, env)); done {
+			return result
+		}
+	}
+	return NULL
+}
+
+// loopBody interprets the result of one pass through a loop body, it
+// reports whether the loop is done and what the loop statement evaluates to.
+func loopBody(obj object.Object) (object.Object, bool) {
+	if obj == nil {
+		return nil, false
+	}
+	switch obj.Type() {
+	case object.BREAK_OBJ:
+		return NULL, true
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return obj, true
+	}
+	return nil, false
+}
+
+// isTruthy goes by type rather than by the NULL, TRUE and FALSE
+// singletons, as objects may also be made by Go code, e.g. object.FromGo.
 func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
 		return false
 	default:
 		return true
@@ -248,10 +572,12 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
@@ -261,14 +587,35 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+func (e *Evaluator) evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+	for _, part := range node.Parts {
+		val := e.Eval(part, env)
+		if isError(val) {
+			return val
+		}
+		out.WriteString(val.Inspect())
 	}
+	return &object.String{Value: out.String()}
+}
 
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
@@ -281,12 +628,22 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		return &object.Integer{Value: leftVal - rightVal}
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+	case "/", "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if operator == "/" {
+			return &object.Integer{Value: leftVal / rightVal}
+		}
+		return &object.Integer{Value: leftVal % rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -296,6 +653,54 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+// evalFloatInfixExpression evaluates operators on two numbers of which at
+// least one is a float; an integer operand is promoted to a float.
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
@@ -308,31 +713,24 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalMinsOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!isTruthy(right))
 }
 
-func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
+func (e *Evaluator) evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	var obj object.Object
 	for _, stmt := range stmts {
-		obj = Eval(stmt, env)
+		obj = e.Eval(stmt, env)
 
 		switch obj := obj.(type) {
 		case *object.ReturnValue:
@@ -344,21 +742,38 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	return obj
 }
 
-func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.Object {
+func (e *Evaluator) evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.Object {
 	var obj object.Object
 	for _, stmt := range stmts {
-		obj = Eval(stmt, env)
+		obj = e.Eval(stmt, env)
 
 		if obj != nil {
 			ot := obj.Type()
-			if ot == object.RETURN_VALUE_OBJ || ot == object.ERROR_OBJ {
+			switch ot {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return obj
 			}
 		}
 	}
+	if obj == nil {
+		// empty blocks and blocks ending in a let statement
+		return NULL
+	}
 	return obj
 }
 
+// trace returns the current call stack, outermost call first, where the
+// innermost function is evaluating the node at pos.
+func (e *Evaluator) trace(pos token.Position) []object.Frame {
+	trace := make([]object.Frame, 0, len(e.frames)+1)
+	function := "<main>"
+	for _, f := range e.frames {
+		trace = append(trace, object.Frame{Function: function, Pos: f.call})
+		function = f.function
+	}
+	return append(trace, object.Frame{Function: function, Pos: pos})
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -366,6 +781,17 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	return FALSE
 }
 
+// recoverPanic turns a Go panic into an error result. It has to be
+// deferred by the entry points of the evaluation.
+func recoverPanic(result *object.Object) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err, _ := r.(error)
+	*result = &object.Error{Message: fmt.Sprintf("panic: %v", r), Err: err}
+}
+
 func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
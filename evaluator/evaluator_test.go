@@ -1,11 +1,16 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/startdusk/tinyscript/ast"
 	"github.com/startdusk/tinyscript/lexer"
 	"github.com/startdusk/tinyscript/object"
 	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/resolver"
 )
 
 func TestEvalIntergerExpression(t *testing.T) {
@@ -60,6 +65,30 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" >= "b"`, true},
+		{`"a" <= "B"`, false},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [1, 2, 3]", false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{"[1, 2] != [2, 1]", true},
+		{"[] == []", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{"1 == 1.0", true},
+		{"[1] == [1.0]", true},
+		{`1 == "1"`, false},
+		{`[1] == {}`, false},
+		{`"" != []`, true},
+		{"let a = [1]; let b = a; a == b", true},
+		{"if (false) { 1 } == if (false) { 2 }", true},
 	}
 
 	for _, tt := range tests {
@@ -69,12 +98,15 @@ func TestEvalBooleanExpression(t *testing.T) {
 }
 
 func testEval(input string) object.Object {
-	l := lexer.New(input)
-	p := parser.New(l)
-	program := p.ParseProgram()
 	env := object.NewEnvironment()
+	return Eval(parse(input, env), env)
+}
 
-	return Eval(program, env)
+// parse parses input and resolves it for evaluation in env.
+func parse(input string, env *object.Environment) *ast.Program {
+	program := parser.New(lexer.New(input)).ParseProgram()
+	resolver.Resolve(program, env, func(name string) bool { return object.GetBuiltinByName(name) != nil })
+	return program
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -92,6 +124,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	res, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not float. got=%T(%+v)", obj, obj)
+		return false
+	}
+
+	if res.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", res.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	res, ok := obj.(*object.Boolean)
 	if !ok {
@@ -194,6 +241,26 @@ func TestErrorHandling(t *testing.T) {
 			"5 + true; 5;",
 			"type mismatch: INTEGER + BOOLEAN",
 		},
+		{
+			`"a ${-true} b"`,
+			"unknown operator: -BOOLEAN",
+		},
+		{
+			"5 / 0",
+			"division by zero",
+		},
+		{
+			"let x = 5; x %= 0",
+			"division by zero",
+		},
+		{
+			"fn(a, b) { a }(1)",
+			"wrong number of arguments: want=2, got=1",
+		},
+		{
+			"let f = fn() { exit(3); 1 }; f(); 2",
+			"exit status 3",
+		},
 		{
 			"-true",
 			"unknown operator: -BOOLEAN",
@@ -223,10 +290,42 @@ func TestErrorHandling(t *testing.T) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"for (x in 5) { x }",
+			"not iterable: INTEGER",
+		},
+		{
+			"x = 5",
+			"assignment to undeclared identifier: x",
+		},
+		{
+			"x += 5",
+			"identifier not found: x",
+		},
+		{
+			"let f = fn() { y = 1 }; f()",
+			"assignment to undeclared identifier: y",
+		},
+		{
+			"let a = [1]; a[3] = 1",
+			"index out of range: 3",
+		},
+		{
+			"let s = 1; s[0] = 1",
+			"index assignment not supported: INTEGER",
+		},
 		{
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			`"a" < 1`,
+			"type mismatch: STRING < INTEGER",
+		},
+		{
+			"[1] < [2]",
+			"unknown operator: ARRAY < ARRAY",
+		},
 		{
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
@@ -258,11 +357,24 @@ func TestLetStatements(t *testing.T) {
 		{"let a = 5 * 5; a;", 25},
 		{"let a = 5; let b = a; b;", 5},
 		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+		{"let a = 1; let a = a + 1; a", 2},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; f()", 2},
+		{"let x = 1; let f = fn() { let g = fn() { let x = x * 3; x }; let x = x + 1; x + g() }; f()", 5},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) + 1 } }; g(3) }; f()", 3},
 	}
 
 	for _, tt := range tests {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
 	}
+
+	// a variable bound to the result of a function without a value is null
+	for _, input := range []string{
+		"let x = fn() {}(); x",
+		"let f = fn() { let a = 1 }; let x = f(); x",
+		"let x = 1; x = fn() {}(); x",
+	} {
+		testNullObject(t, testEval(input))
+	}
 }
 
 func TestFunctionObject(t *testing.T) {
@@ -315,6 +427,60 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"let a = 1; let f = fn() { fn() { fn() { a } } }; f()()()", 1},
+		{"let f = fn(x) { fn() { fn() { x * 2 } } }; f(21)()()", 42},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; f() + x", 3},
+		{"let f = fn() { g() }; let g = fn() { 5 }; f()", 5},
+		{"let f = fn(n) { if (n == 0) { 0 } else { n + f(n - 1) } }; f(4)", 10},
+		{"let f = fn() { if (true) { let y = 2 }; y }; f()", 2},
+		{"let f = fn() { for (k, v in [5, 6]) {}; k + v }; f()", 7},
+		{"let len = fn(x) { 0 }; len([1, 2])", 0},
+		{"let f = fn() { y; let y = 1 }; f()", "identifier not found: y"},
+		{"let f = fn() { y = 1; let y = 2 }; f()", "assignment to undeclared identifier: y"},
+		{"x; let x = 1", "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message for %q. expected=%q, got=%q", tt.input, expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestEnvironmentSharedAcrossPrograms(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("base", &object.Integer{Value: 10})
+	for _, input := range []string{
+		"let add = fn(n) { base + n };",
+		"let base = 20;",
+	} {
+		if result := Eval(parse(input, env), env); isError(result) {
+			t.Fatalf("Eval(%q) failed: %s", input, result.Inspect())
+		}
+	}
+	testIntegerObject(t, Eval(parse("add(1)", env), env), 21)
+
+	// bound by name in an outer environment, not known to the resolver
+	inner := object.NewEncloedEnvironment(env)
+	testIntegerObject(t, Eval(parse("add(base)", inner), inner), 40)
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World"`
 
@@ -343,6 +509,33 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "Ann"; "hello ${name}!"`, "hello Ann!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1, 2]"},
+		{`"${1 + 2}${true}${2.5}"`, "3true2.5"},
+		{`let f = fn(s) { s + "!" }; "${f("}")}"`, "}!"},
+		{`let x = 1; "a ${"b ${x}"} c"`, "a b 1 c"},
+		{`"${fn(x, y) { x + y }}"`, "fn(x, y) {\n(x + y)\n}"},
+		{`"\${x}"`, "${x}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value. want=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -374,6 +567,178 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3}`, `{b: 1, a: 2, 3: 3}`},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, `{b: 4, a: 2, c: 3}`},
+		{`keys({"z": 1, "y": 2, "x": 3})`, `[z, y, x]`},
+		{`values({"z": 1, "y": 2, "x": 3})`, `[1, 2, 3]`},
+		{`entries({"a": 1, 2: "b"})`, `[[a, 1], [2, b]]`},
+		{`len({"a": 1, "b": 2})`, `2`},
+		{`len({})`, `0`},
+		{`has({"a": 1}, "a")`, `true`},
+		{`has({"a": 1}, "b")`, `false`},
+		{`!has({"a": 1}, "b")`, `true`},
+		{`has({1: 1}, 1.0)`, `true`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b")`, `2`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b"); h["d"] = 4; h`, `{a: 1, c: 3, d: 4}`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "a"); h["c"]`, `3`},
+		{`delete({}, "x")`, `null`},
+		{`let h = {}; set(h, "a", 1); set(h, "b", 2); set(h, "a", 3); h`, `{a: 3, b: 2}`},
+		{`let a = {"x": 1, "y": 2}; let b = {"y": 3, "z": 4}; merge(a, b)`, `{x: 1, y: 3, z: 4}`},
+		{`let a = {"x": 1}; merge(a, {"y": 2}); a`, `{x: 1}`},
+		{`let s = ""; for (k, v in {"c": 1, "a": 2, "b": 3}) { s += k }; s`, `cab`},
+		{`keys(1)`, "ERROR: argument to `keys` must be HASH, got INTEGER"},
+		{`has({}, [])`, "ERROR: unusable as hash key: ARRAY"},
+		{`set({}, "a")`, "ERROR: wrong number of arguments. got=2, want=3"},
+		{`merge()`, "ERROR: wrong number of arguments. got=0, want=1 or more"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, `[2, 4, 6]`},
+		{`map([], fn(x) { x })`, `[]`},
+		{`map(["a", "bc"], len)`, `[1, 2]`},
+		{`let k = 10; map([1, 2], fn(x) { x + k })`, `[11, 12]`},
+		{`map([[1, 2], [3]], fn(a) { map(a, fn(x) { x * x }) })`, `[[1, 4], [9]]`},
+		{`map([1], fn(x) { if (false) { 1 } })`, `[null]`},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, `[2, 4]`},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x })`, `6`},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)`, `16`},
+		{`reduce([], fn(acc, x) { acc + x })`, `null`},
+		{`let s = 0; each([1, 2, 3], fn(x) { s = s + x }); s`, `6`},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, `2`},
+		{`find([1, 2, 3], fn(x) { x > 3 })`, `null`},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, `true`},
+		{`any([])`, `false`},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, `true`},
+		{`all([1, false])`, `false`},
+		{`sort([3, 1.5, 2])`, `[1.5, 2, 3]`},
+		{`sort(["b", "c", "a"])`, `[a, b, c]`},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, `[3, 2, 1]`},
+		{`sort([[2, "b"], [1, "a"], [2, "a"]], fn(a, b) { a[0] - b[0] })`, `[[1, a], [2, b], [2, a]]`},
+		{`let a = [2, 1]; sort(a); a`, `[2, 1]`},
+		{`reverse([1, 2, 3])`, `[3, 2, 1]`},
+		{`reverse("héllo")`, `olléh`},
+		{`zip([1, 2, 3], ["a", "b"])`, `[[1, a], [2, b]]`},
+		{`range(4)`, `[0, 1, 2, 3]`},
+		{`range(1, 4)`, `[1, 2, 3]`},
+		{`range(5, 0, -2)`, `[5, 3, 1]`},
+		{`range(0)`, `[]`},
+		{`range(9223372036854775806, 9223372036854775807, 2)`, `[9223372036854775806]`},
+		{`range(-9223372036854775807 - 1, 9223372036854775807, 9223372036854775807)`, `[-9223372036854775808, -1, 9223372036854775806]`},
+		{`range(9223372036854775807, -9223372036854775807 - 1, -9223372036854775807 - 1)`, `[9223372036854775807, -1]`},
+		{`flatten([1, [2, [3]], []])`, `[1, 2, [3]]`},
+		{`flatten([1, [2, [3]]], 2)`, `[1, 2, 3]`},
+		{`let a = [1, [2]]; flatten([a, a], 2)`, `[1, 2, 1, 2]`},
+		{`let a = [1]; a[0] = a; flatten(a, 1000000)`, `ERROR: cannot flatten an ARRAY holding itself`},
+		{`let a = [1]; for (i in range(40)) { let a = [a, a] }; flatten(a, 100)`, "ERROR: result of `flatten` too large: more than 16777216 elements"},
+		{`uniq([1, 2, 1, "a", "a", [1], [1]])`, `[1, 2, a, [1]]`},
+		{`slice([1, 2, 3, 4], 1)`, `[2, 3, 4]`},
+		{`slice([1, 2, 3, 4], 1, 3)`, `[2, 3]`},
+		{`slice([1, 2, 3, 4], -2)`, `[3, 4]`},
+		{`slice([1, 2, 3, 4], 3, 1)`, `[]`},
+		{`slice([1, 2, 3, 4], -10, 10)`, `[1, 2, 3, 4]`},
+		{`index_of([1, 2, 3], 2)`, `1`},
+		{`index_of([[1]], [1])`, `0`},
+		{`index_of([1, 2, 3], 4)`, `-1`},
+		{`join([1, "a", true])`, `1atrue`},
+		{`join(["a", "b"], ", ")`, `a, b`},
+		{`map([1, 0], fn(x) { 1 / x })`, `ERROR: division by zero`},
+		{`map([1], fn(x, y) { x })`, `ERROR: wrong number of arguments: want=2, got=1`},
+		{`map([1], 1)`, `ERROR: not a function: INTEGER`},
+		{`map(1, len)`, "ERROR: argument to `map` must be ARRAY, got INTEGER"},
+		{`filter([1], len)`, "ERROR: argument to `len` not supported, got INTEGER"},
+		{`sort([1, "a"])`, `ERROR: cannot compare STRING and INTEGER`},
+		{`sort([1, 2], fn(a, b) { "x" })`, `ERROR: comparator must return BOOLEAN or INTEGER, got STRING`},
+		{`range(1, 2, 0)`, `ERROR: range step must not be zero`},
+		{`range(0, 2000000000)`, "ERROR: result of `range` too large: 2000000000 elements, at most 16777216"},
+		{`join(["a"], 1)`, "ERROR: separator of `join` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len("héllo")`, `5`},
+		{`"héllo"[1]`, `é`},
+		{`"héllo"[4]`, `o`},
+		{`"héllo"[5]`, `null`},
+		{`"héllo"[-1]`, `null`},
+		{`let s = ""; for (c in "日本") { s = c + s }; s`, `本日`},
+		{`split("a,b,,c", ",")`, `[a, b, , c]`},
+		{`split("  a b\tc ")`, `[a, b, c]`},
+		{`split("日本", "")`, `[日, 本]`},
+		{`join(split("a b c"), "-")`, `a-b-c`},
+		{`trim("  a b  ")`, `a b`},
+		{`trim("xxaxx", "x")`, `a`},
+		{`upper("héllo")`, `HÉLLO`},
+		{`lower("ÀB")`, `àb`},
+		{`replace("a-b-c", "-", "+")`, `a+b+c`},
+		{`replace("a-b-c", "-", "+", 1)`, `a+b-c`},
+		{`contains("héllo", "él")`, `true`},
+		{`contains("héllo", "x")`, `false`},
+		{`starts_with("héllo", "hé")`, `true`},
+		{`ends_with("héllo", "hé")`, `false`},
+		{`index("日本語", "語")`, `2`},
+		{`index("日本語", "x")`, `-1`},
+		{`substr("héllo", 1)`, `éllo`},
+		{`substr("héllo", 1, 3)`, `éll`},
+		{`substr("héllo", -2, 10)`, `lo`},
+		{`substr("héllo", 10)`, ``},
+		{`slice("héllo", 1, -1)`, `éll`},
+		{`repeat("ab", 3)`, `ababab`},
+		{`repeat("ab", 0)`, ``},
+		{`pad_left("é", 3)`, `  é`},
+		{`pad_right("é", 4, "ab")`, `éaba`},
+		{`pad_left("héllo", 2, "0")`, `héllo`},
+		{`chars("hé")`, `[h, é]`},
+		{`chars("")`, `[]`},
+		{`format("%s is %d years", "Ann", 42)`, `Ann is 42 years`},
+		{`format("%5.2f|%-4s|%x|%t|%q|100%%", 3.14159, "é", 255, true, "a")`, ` 3.14|é   |ff|true|"a"|100%`},
+		{`format("%v %s", [1, "a"], {"k": 1})`, `[1, a] {k: 1}`},
+		{`format("%.1f", 2)`, `2.0`},
+		{`format("%d", "a")`, `ERROR: format: cannot format STRING with %d`},
+		{`format("%d %d", 1)`, `ERROR: format: missing argument for %d`},
+		{`format("%d", 1, 2)`, `ERROR: format: 1 arguments left over`},
+		{`format("%y", 1)`, `ERROR: format: unknown verb %y`},
+		{`format("100%")`, `ERROR: format: missing verb at the end of "100%"`},
+		{`upper(1)`, "ERROR: argument to `upper` must be STRING, got INTEGER"},
+		{`split("a", 1)`, "ERROR: argument to `split` must be STRING, got INTEGER"},
+		{`repeat("a", -1)`, "ERROR: count of `repeat` must not be negative, got -1"},
+		{`substr("a", 0, -1)`, "ERROR: length of `substr` must not be negative, got -1"},
+		{`pad_left("a", 3, "")`, "ERROR: padding of `pad_left` must not be empty"},
+		{`"abc"["a"]`, `ERROR: index operator not supported: STRING`},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -464,23 +829,25 @@ func TestHashLiterals(t *testing.T) {
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := []struct {
+		key   object.HashKey
+		value int64
+	}{
+		{(&object.String{Value: "one"}).HashKey(), 1},
+		{(&object.String{Value: "two"}).HashKey(), 2},
+		{(&object.String{Value: "three"}).HashKey(), 3},
+		{(&object.Integer{Value: 4}).HashKey(), 4},
+		{TRUE.HashKey(), 5},
+		{FALSE.HashKey(), 6},
 	}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+	for i, pair := range result.Pairs() {
+		if pair.Key.(object.Hashable).HashKey() != expected[i].key {
+			t.Errorf("pair %d has wrong key. got=%s", i, pair.Key.Inspect())
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -529,3 +896,384 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; let sum = 0; while (i < 5) { let i = i + 1; let sum = sum + i; }; sum", 15},
+		{"let i = 0; while (true) { if (i == 3) { break; } let i = i + 1; }; i", 3},
+		{"let i = 0; let sum = 0; while (i < 6) { let i = i + 1; if (i == 3) { continue; } let sum = sum + i; }; sum", 18},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { let sum = sum + i * x; }; sum", 80},
+		{"let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } let sum = sum + x; }; sum", 4},
+		{`let n = 0; for (c in "héllo") { let n = n + 1; }; n`, 5},
+		{`let n = 0; for (i, c in "abc") { let n = n + i; }; n`, 3},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2}) { let sum = sum + v; }; sum`, 3},
+		{"let sum = 0; for (k in {1: 10, 2: 20}) { let sum = sum + k; }; sum", 3},
+		{`let n = 0;
+		for (i in [1, 2, 3]) {
+			for (j in [1, 2, 3]) {
+				if (j == 2) { break; }
+				let n = n + 1;
+			}
+		};
+		n`, 3},
+		{`let f = fn() {
+			for (x in [1, 2, 3]) {
+				if (x == 2) { return x * 10; }
+			}
+			return 0;
+		};
+		f()`, 20},
+		{`let f = fn(n) {
+			let i = 0;
+			let acc = 1;
+			while (i < n) { let i = i + 1; let acc = acc * 2; }
+			acc
+		};
+		f(5)`, 32},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoopValue(t *testing.T) {
+	tests := []string{
+		"let f = fn() { while (false) {} }; f()",
+		"let f = fn() { let i = 0; while (true) { i += 1; if (i == 2) { break } } }; f()",
+		"let f = fn() { for (x in [1, 2]) { x } }; f()",
+		"let f = fn() { for (x in [1, 2]) { break } }; f()",
+		"let r = if (true) { while (false) {} }; r",
+		"let f = fn() { for (x in []) {} }; puts(f()); f()",
+	}
+
+	for _, input := range tests {
+		testNullObject(t, testEval(input))
+	}
+}
+
+// TestNoValue checks that functions and blocks without a value evaluate
+// to null, also inside containers.
+func TestNoValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() {}()", "null"},
+		{"fn() { let a = 1 }()", "null"},
+		{"if (true) {}", "null"},
+		{"if (true) { let y = 1 }", "null"},
+		{"[fn() {}()]", "[null]"},
+		{"let f = fn() {}; {1: f()}", "{1: null}"},
+		{"let f = fn() {}; \"${f()}\"", "null"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("no value for %q", tt.input)
+			continue
+		}
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x += 2; x", 3},
+		{"let x = 10; x -= 3; x *= 2; x /= 7; x", 2},
+		{"let x = 17; x %= 5; x", 2},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let x = 1; let y = (x = 4) + 1; x + y", 9},
+		{"let arr = [1, 2, 3]; arr[1] = 20; arr[1]", 20},
+		{"let arr = [1, 2, 3]; arr[2] += 5; arr[2]", 8},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += 10; h["a"] + h["b"]`, 13},
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next(); next()", 3},
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let a = counter(); let b = counter(); a(); a(); b(); a()", 3},
+		{"let f = fn() { let x = 1; let inc = fn() { x = x + 10 }; inc(); x }; f()", 11},
+		{"let total = 0; let add = fn(n) { total += n }; add(3); add(4); total", 7},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; }; sum", 15},
+		{"let f = fn() { let i = 0; while (i < 3) { i = i + 1 }; i }; f()", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1e3", 1000.0},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"let m = 7.5; m %= 2; m", 1.5},
+		{"10 - 2.5 * 2", 5.0},
+		{"let avg = fn(a, b) { (a + b) / 2.0 }; avg(1, 2)", 1.5},
+		{"let x = 1; x += 0.25; x", 1.25},
+		{"float(3)", 3.0},
+		{`float("2.5")`, 2.5},
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{`int("42")`, 42},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"{1: 10}[1.0]", 10},
+		{`float("abc")`, "cannot convert \"abc\" to FLOAT"},
+		{"int(1e300)", "cannot convert 1e+300 to INTEGER"},
+		{"int(-9.3e18)", "cannot convert -9.3e+18 to INTEGER"},
+		{"int(-9.2e18)", -9200000000000000000},
+		{"int(true)", "argument to `int` not supported, got BOOLEAN"},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLogicalAndComparisonOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && false", false},
+		{"true || false", true},
+		{"1 && 2", 2},
+		{"false || 0", 0},
+		{"false && x", false},
+		{"true || x", true},
+		{"let n = 0; let inc = fn() { n += 1; true }; false && inc(); true || inc(); n", 0},
+		{"let n = 0; let inc = fn() { n += 1; true }; true && inc(); false || inc(); n", 2},
+		{"let a = [1, 2, 3]; let x = 2; x >= 0 && x < len(a)", true},
+		{"let a = [1, 2, 3]; let x = 3; x >= 0 && x < len(a)", false},
+		{"1 + 1 == 2 && 3 > 2 || false", true},
+		{"if (1 > 2 || 2 >= 2) { 10 } else { 20 }", 10},
+		{"3 <= 3", true},
+		{"4 >= 5", false},
+		{"2.5 >= 2", true},
+		{"1 <= 0.5", false},
+		{"10 % 3", 1},
+		{"-7 % 3", -1},
+		{"7.5 % 2", 1.5},
+		{"2 + 10 % 4 * 2", 6},
+		{"1 <= true", "type mismatch: INTEGER <= BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + y
+};
+let outer = fn(x) { inner(x) };
+let run = fn(f) { f(1) };
+run(fn(x) { outer(x) });`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := []struct {
+		function string
+		pos      string
+	}{
+		{"<main>", "6:1"},
+		{"run", "5:19"},
+		{"<anonymous>", "6:13"},
+		{"outer", "4:21"},
+		{"inner", "2:7"},
+	}
+	if len(errObj.Trace) != len(expected) {
+		t.Fatalf("wrong trace length. want=%d, got=%d (%+v)", len(expected), len(errObj.Trace), errObj.Trace)
+	}
+	for i, f := range expected {
+		if errObj.Trace[i].Function != f.function {
+			t.Errorf("trace[%d] wrong function. want=%q, got=%q", i, f.function, errObj.Trace[i].Function)
+		}
+		if errObj.Trace[i].Pos.String() != f.pos {
+			t.Errorf("trace[%d] wrong position. want=%q, got=%q", i, f.pos, errObj.Trace[i].Pos)
+		}
+	}
+
+	want := `Traceback (most recent call last):
+  6:1, in <main>
+  5:19, in run
+  6:13, in <anonymous>
+  4:21, in outer
+  2:7, in inner
+ERROR: identifier not found: y`
+	if errObj.StackTrace() != want {
+		t.Errorf("wrong stack trace.\nwant=\n%s\ngot=\n%s", want, errObj.StackTrace())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input           string
+		limits          Limits
+		expectedMessage string
+	}{
+		{"while (true) {}", Limits{MaxSteps: 1000}, "limit exceeded: more than 1000 steps"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", Limits{MaxDepth: 50}, "stack overflow: more than 50 nested calls"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", Limits{}, "stack overflow: more than 10000 nested calls"},
+		{"while (true) {}", Limits{Timeout: 10 * time.Millisecond}, "limit exceeded: timeout after 10ms"},
+		{`let s = ""; while (true) { s += "xxxxxxxxxx"; }`, Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{"let a = []; while (true) { let a = push(a, 1); }", Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{"len(range(0, 10000000))", Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{`repeat("x", 1000000000)`, Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{`pad_left("x", 1000000000, "ab")`, Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{`replace(repeat("x", 1000), "", repeat("y", 100000))`, Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{`let s = repeat("x", 600000); repeat(s, 1)`, Limits{MaxAlloc: 1 << 20}, "limit exceeded: more than 1048576 bytes allocated"},
+		{"let a = range(100000); flatten([a, a, a])", Limits{MaxAlloc: 1 << 22}, "limit exceeded: more than 4194304 bytes allocated"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		e := New()
+		e.SetLimits(tt.limits)
+		evaluated := e.EvalContext(context.Background(), parse(tt.input, env), env)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, errObj.Message)
+		}
+		if !errors.Is(errObj, ErrLimitExceeded) {
+			t.Errorf("error of %q is not ErrLimitExceeded", tt.input)
+		}
+	}
+
+	// limits only apply to the run they are set for
+	e := New()
+	e.SetLimits(Limits{MaxSteps: 100})
+	env := object.NewEnvironment()
+	testIntegerObject(t, e.Eval(parse("let i = 0; while (i < 100) { i += 1 }; i", env), env), 100)
+
+	// builtins within the budget run
+	e.SetLimits(Limits{MaxAlloc: 1 << 20})
+	env = object.NewEnvironment()
+	program := parse(`len(repeat("x", 1000)) + len(range(1000)) + len(pad_left("", 1000))`, env)
+	testIntegerObject(t, e.EvalContext(context.Background(), program, env), 3000)
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	env := object.NewEnvironment()
+	evaluated := New().EvalContext(ctx, parse("while (true) {}", env), env)
+	if !errors.Is(evaluated.(error), context.Canceled) {
+		t.Errorf("expected context.Canceled, got=%v", evaluated)
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedCode int
+	}{
+		{"exit()", 0},
+		{"exit(2)", 2},
+		{"let i = 0; while (true) { i += 1; if (i == 3) { exit(i) } }", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		var exit *object.ExitError
+		if err, ok := evaluated.(error); !ok || !errors.As(err, &exit) {
+			t.Errorf("%q did not exit. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if exit.Code != tt.expectedCode {
+			t.Errorf("wrong exit code. want=%d, got=%d", tt.expectedCode, exit.Code)
+		}
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	boom := errors.New("boom")
+	e := NewWithBuiltins(map[string]*object.Builtin{
+		"boom": {Fn: func(args ...object.Object) object.Object { panic(boom) }},
+	})
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New("let f = fn() { boom() }; f()")).ParseProgram()
+	resolver.Resolve(program, env, func(name string) bool { return name == "boom" })
+	evaluated := e.EvalContext(context.Background(), program, env)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "panic: boom" || !errors.Is(errObj, boom) {
+		t.Errorf("wrong error. got=%q", errObj.Message)
+	}
+	if len(e.frames) != 0 {
+		t.Errorf("call stack not unwound. got=%d frames", len(e.frames))
+	}
+}
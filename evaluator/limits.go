@@ -0,0 +1,144 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/object"
+)
+
+// ErrLimitExceeded is the cause of the errors evaluation stops with when
+// it exceeds one of its Limits, see object.Error.Err.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// DefaultMaxDepth is the maximum nesting of function calls if
+// Limits.MaxDepth is not set. It keeps runaway recursion from overflowing
+// the Go stack.
+const DefaultMaxDepth = 10000
+
+// Limits bounds the resources used by EvalContext and ApplyContext. Zero
+// fields are unlimited, except for MaxDepth.
+type Limits struct {
+	MaxSteps int64         // evaluated AST nodes
+	MaxDepth int           // nested function calls, DefaultMaxDepth if zero
+	Timeout  time.Duration // wall-clock time
+	MaxAlloc int64         // approximate bytes allocated for strings, arrays and hashes
+}
+
+// checkInterval is how many steps pass between checks of the context and
+// the timeout, which are too expensive to check on every step.
+const checkInterval = 1024
+
+// SetLimits sets the limits of the next EvalContext and ApplyContext calls.
+func (e *Evaluator) SetLimits(limits Limits) {
+	e.limits = limits
+}
+
+// EvalContext evaluates node in env like Eval, but stops with an error once
+// ctx is done or a limit set by SetLimits is exceeded. Like the package
+// level Eval, it turns Go panics into errors.
+func (e *Evaluator) EvalContext(ctx context.Context, node ast.Node, env *object.Environment) (result object.Object) {
+	defer recoverPanic(&result)
+	defer e.start(ctx)()
+	return e.Eval(node, env)
+}
+
+// ApplyContext calls fn with args like Apply, but stops with an error once
+// ctx is done or a limit set by SetLimits is exceeded. Like the package
+// level Eval, it turns Go panics into errors.
+func (e *Evaluator) ApplyContext(ctx context.Context, fn object.Object, args ...object.Object) (result object.Object) {
+	defer recoverPanic(&result)
+	defer e.start(ctx)()
+	return e.Apply(fn, args...)
+}
+
+// start resets the resource usage for a run under ctx, and returns a
+// function ending the run.
+func (e *Evaluator) start(ctx context.Context) func() {
+	e.ctx = ctx
+	e.steps = 0
+	e.alloc = 0
+	e.deadline = time.Time{}
+	if e.limits.Timeout > 0 {
+		e.deadline = time.Now().Add(e.limits.Timeout)
+	}
+	return func() { e.ctx = nil }
+}
+
+// step counts an evaluation step of a run and reports an error if the
+// run has to stop.
+func (e *Evaluator) step() *object.Error {
+	e.steps++
+	if e.limits.MaxSteps > 0 && e.steps > e.limits.MaxSteps {
+		return limitError("more than %d steps", e.limits.MaxSteps)
+	}
+	if e.steps%checkInterval != 0 {
+		return nil
+	}
+	if err := e.ctx.Err(); err != nil {
+		return &object.Error{Message: err.Error(), Err: err}
+	}
+	if !e.deadline.IsZero() && time.Now().After(e.deadline) {
+		return limitError("timeout after %s", e.limits.Timeout)
+	}
+	return nil
+}
+
+// allocates reports whether evaluating node makes a new value, rather than
+// returning an existing one. Calls are charged by applyFunction.
+func allocates(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.ArrayLiteral, *ast.HashLiteral, *ast.InterpolatedString, *ast.InfixExpression:
+		return true
+	case *ast.AssignExpression:
+		return node.Operator != "="
+	default:
+		return false
+	}
+}
+
+// allocated charges the allocation of obj against the budget of a run.
+func (e *Evaluator) allocated(obj object.Object) *object.Error {
+	if e.ctx == nil || e.limits.MaxAlloc <= 0 {
+		return nil
+	}
+	switch obj := obj.(type) {
+	case *object.String:
+		e.alloc += int64(len(obj.Value))
+	case *object.Array:
+		e.alloc += int64(len(obj.Elements)) * object.ElementSize
+	case *object.Hash:
+		e.alloc += int64(obj.Len()) * object.PairSize
+	}
+	if e.alloc > e.limits.MaxAlloc {
+		return limitError("more than %d bytes allocated", e.limits.MaxAlloc)
+	}
+	return nil
+}
+
+// reserve reports an error if allocating size more bytes would exceed the
+// budget of a run. Builtins check their results with it before making them,
+// which are charged once made.
+func (e *Evaluator) reserve(size int64) *object.Error {
+	if e.ctx == nil || e.limits.MaxAlloc <= 0 {
+		return nil
+	}
+	if size > e.limits.MaxAlloc-e.alloc {
+		return limitError("more than %d bytes allocated", e.limits.MaxAlloc)
+	}
+	return nil
+}
+
+func (e *Evaluator) maxDepth() int {
+	if e.limits.MaxDepth > 0 {
+		return e.limits.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+func limitError(format string, a ...any) *object.Error {
+	return &object.Error{Message: "limit exceeded: " + fmt.Sprintf(format, a...), Err: ErrLimitExceeded}
+}
@@ -0,0 +1,490 @@
+// Package format prints tinyscript programs in a canonical style.
+//
+// Statements go on lines of their own, indented by tabs, and statements
+// other than blocks end with a semicolon. Expressions get single spaces
+// around binary operators and only the parentheses their meaning needs.
+// Comments are kept, on the line they were on or above the code following
+// them, and so are single blank lines between statements. Blocks, arrays,
+// hashes and argument lists stay on one line if they were on one line.
+//
+// Formatting formatted code changes nothing.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/token"
+)
+
+// SyntaxError is returned by Source for a program that does not parse.
+type SyntaxError struct {
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Source formats the program src.
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(string(src), lexer.WithComments()))
+	program := p.ParseProgram()
+	if diags := p.Diagnostics(); len(diags) != 0 {
+		return nil, &SyntaxError{Diagnostics: diags}
+	}
+
+	var out bytes.Buffer
+	if err := Fprint(&out, src, program); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Fprint writes program to w, with the comments in program.Comments. src
+// is the source program was parsed from, which tells raw strings from
+// quoted ones; without it all strings are printed quoted.
+func Fprint(w io.Writer, src []byte, program *ast.Program) error {
+	p := &printer{src: src}
+	for _, group := range program.Comments {
+		p.comments = append(p.comments, group.List...)
+	}
+	p.stmtList(program.Statements, math.MaxInt)
+	_, err := w.Write(p.out.Bytes())
+	return err
+}
+
+type printer struct {
+	src []byte
+	out bytes.Buffer
+
+	indent      int
+	atLineStart bool
+
+	comments []*ast.Comment
+	next     int // index of the first comment not printed yet
+	lastLine int // source line of the last code or comment printed, 0 at the start of a block
+}
+
+// write writes s, indenting it if it starts a line.
+func (p *printer) write(s string) {
+	if p.atLineStart && s != "" {
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.atLineStart = false
+	}
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.out.WriteByte('\n')
+	p.atLineStart = true
+}
+
+// blankLine keeps a blank line before code or a comment starting on line,
+// if there was one in the source.
+func (p *printer) blankLine(line int) {
+	if p.lastLine > 0 && line > p.lastLine+1 {
+		p.newline()
+	}
+}
+
+// ============================================================================
+// Comments
+
+// leadingComments prints the comments before offset on lines of their own.
+func (p *printer) leadingComments(offset int) {
+	for p.next < len(p.comments) && p.comments[p.next].Pos().Offset < offset {
+		c := p.comments[p.next]
+		p.blankLine(c.Pos().Line)
+		p.write(c.Token.Literal)
+		p.newline()
+		p.lastLine = c.End().Line
+		p.next++
+	}
+}
+
+// trailingComments prints the comments before the end of a node, which
+// were left inside it, and those on the line it ends on, after the code on
+// the current line. limit is the offset of the code following the node.
+func (p *printer) trailingComments(end token.Position, limit int) {
+	lineComment := false
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if c.Pos().Offset >= limit || c.Pos().Offset >= end.Offset && c.Pos().Line != end.Line {
+			break
+		}
+		if lineComment {
+			p.newline()
+		} else {
+			p.write(" ")
+		}
+		p.write(c.Token.Literal)
+		lineComment = strings.HasPrefix(c.Token.Literal, "//")
+		if c.End().Line > p.lastLine {
+			p.lastLine = c.End().Line
+		}
+		p.next++
+	}
+}
+
+// hasComments reports whether comments not printed yet start in node.
+func (p *printer) hasComments(node ast.Node) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Pos().Offset < node.End().Offset
+}
+
+// ============================================================================
+// Statements
+
+// stmtList prints stmts one per line, and the comments before the offset
+// end.
+func (p *printer) stmtList(stmts []ast.Statement, end int) {
+	p.lastLine = 0
+	for i, stmt := range stmts {
+		p.leadingComments(stmt.Pos().Offset)
+		p.blankLine(stmt.Pos().Line)
+
+		var next ast.Statement
+		limit := end
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+			limit = next.Pos().Offset
+		}
+		p.stmt(stmt)
+		if needsSemicolon(stmt, next) {
+			p.write(";")
+		}
+		p.lastLine = stmt.End().Line
+		p.trailingComments(stmt.End(), limit)
+		p.newline()
+	}
+	p.leadingComments(end)
+}
+
+func (p *printer) stmt(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + stmt.Name.Value + " = ")
+		p.expr(stmt.Value, parser.LOWEST)
+	case *ast.ReturnStatement:
+		p.write("return")
+		if stmt.ReturnValue != nil {
+			p.write(" ")
+			p.expr(stmt.ReturnValue, parser.LOWEST)
+		}
+	case *ast.ExpressionStatement:
+		p.expr(stmt.Expression, parser.LOWEST)
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expr(stmt.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body)
+	case *ast.ForStatement:
+		p.write("for (")
+		if stmt.Key != nil {
+			p.write(stmt.Key.Value + ", ")
+		}
+		p.write(stmt.Value.Value + " in ")
+		p.expr(stmt.Iterable, parser.LOWEST)
+		p.write(") ")
+		p.block(stmt.Body)
+	case *ast.BreakStatement:
+		p.write("break")
+	case *ast.ContinueStatement:
+		p.write("continue")
+	default:
+		panic(fmt.Sprintf("format: unexpected statement %T", stmt))
+	}
+}
+
+// needsSemicolon reports whether stmt ends with a semicolon, which all
+// statements but loops do. An if expression, which ends with a block, only
+// needs one to keep the next statement from continuing it.
+func needsSemicolon(stmt, next ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.WhileStatement, *ast.ForStatement:
+		return false
+	case *ast.ExpressionStatement:
+		if _, ok := stmt.Expression.(*ast.IfExpression); ok {
+			next, ok := next.(*ast.ExpressionStatement)
+			return ok && continues(next.Expression)
+		}
+	}
+	return true
+}
+
+// continues reports whether expr starts with a parenthesis, a bracket or a
+// minus sign, which would make it continue the expression before it.
+func continues(expr ast.Expression) bool {
+	for {
+		switch e := expr.(type) {
+		case *ast.InfixExpression:
+			if precedence(e.Left) < parser.Precedence(token.TokenType(e.Operator)) {
+				return true
+			}
+			expr = e.Left
+		case *ast.AssignExpression:
+			expr = e.Target
+		case *ast.CallExpression:
+			if calleeNeedsParens(e.Function) {
+				return true
+			}
+			expr = e.Function
+		case *ast.IndexExpression:
+			if precedence(e.Left) < parser.CALL {
+				return true
+			}
+			expr = e.Left
+		case *ast.PrefixExpression:
+			return e.Operator == "-"
+		case *ast.ArrayLiteral:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// block prints a block, on one line if it was on one line and has no
+// comments.
+func (p *printer) block(block *ast.BlockStatement) {
+	if p.hasComments(block) || block.Token.Pos.Line != block.Rbrace.Pos.Line {
+		p.write("{")
+		p.newline()
+		p.indent++
+		p.stmtList(block.Statements, block.Rbrace.Pos.Offset)
+		p.indent--
+		p.write("}")
+		return
+	}
+
+	if len(block.Statements) == 0 {
+		p.write("{}")
+		return
+	}
+	p.write("{ ")
+	for i, stmt := range block.Statements {
+		if i > 0 {
+			p.write("; ")
+		}
+		p.stmt(stmt)
+	}
+	p.write(" }")
+}
+
+// ============================================================================
+// Expressions
+
+// precedence returns how tightly expr binds, as the precedence of the
+// operator with the lowest precedence outside parentheses in it. Calls and
+// index expressions both take CALL, as either can follow the other.
+func precedence(expr ast.Expression) int {
+	switch e := expr.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(token.TokenType(e.Operator))
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression, *ast.IndexExpression:
+		return parser.CALL
+	default:
+		return parser.INDEX
+	}
+}
+
+// calleeNeedsParens reports whether the function called by a call
+// expression is put in parentheses. Function literals are, although they
+// need not be, to set their body apart from the arguments.
+func calleeNeedsParens(fn ast.Expression) bool {
+	_, literal := fn.(*ast.FunctionLiteral)
+	return literal || precedence(fn) < parser.CALL
+}
+
+// expr prints expr, in parentheses if it binds less tightly than prec.
+func (p *printer) expr(expr ast.Expression, prec int) {
+	if precedence(expr) < prec {
+		p.write("(")
+		p.expr(expr, parser.LOWEST)
+		p.write(")")
+		return
+	}
+
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+	case *ast.IntegerLiteral:
+		p.write(e.Token.Literal)
+	case *ast.FloatLiteral:
+		p.write(e.Token.Literal)
+	case *ast.Boolean:
+		p.write(e.String())
+	case *ast.StringLiteral:
+		p.stringLiteral(e)
+	case *ast.InterpolatedString:
+		p.interpolatedString(e)
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.expr(e.Right, parser.PREFIX)
+	case *ast.InfixExpression:
+		prec := parser.Precedence(token.TokenType(e.Operator))
+		p.expr(e.Left, prec)
+		p.write(" " + e.Operator + " ")
+		p.expr(e.Right, prec+1)
+	case *ast.AssignExpression:
+		p.expr(e.Target, parser.ASSIGN+1)
+		p.write(" " + e.Operator + " ")
+		p.expr(e.Value, parser.ASSIGN)
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expr(e.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		params := make([]string, len(e.Parameters))
+		for i, param := range e.Parameters {
+			params[i] = param.Value
+		}
+		p.write("fn(" + strings.Join(params, ", ") + ") ")
+		p.block(e.Body)
+	case *ast.CallExpression:
+		if calleeNeedsParens(e.Function) {
+			p.write("(")
+			p.expr(e.Function, parser.LOWEST)
+			p.write(")")
+		} else {
+			p.expr(e.Function, parser.CALL)
+		}
+		p.list("(", e.Arguments, ")", e.Token, e.Rparen, p.listItem)
+	case *ast.IndexExpression:
+		p.expr(e.Left, parser.CALL)
+		p.write("[")
+		p.expr(e.Index, parser.LOWEST)
+		p.write("]")
+	case *ast.ArrayLiteral:
+		p.list("[", e.Elements, "]", e.Token, e.Rbracket, p.listItem)
+	case *ast.HashLiteral:
+		p.list("{", e.Keys, "}", e.Token, e.Rbrace, func(key ast.Expression) token.Position {
+			p.expr(key, parser.LOWEST)
+			p.write(": ")
+			p.expr(e.Pairs[key], parser.LOWEST)
+			return e.Pairs[key].End()
+		})
+	default:
+		panic(fmt.Sprintf("format: unexpected expression %T", expr))
+	}
+}
+
+// list prints the items of an argument list, an array or a hash between
+// the open and close tokens, on one line if the first item was on the line
+// of open, and one item per line otherwise. item prints an item and returns
+// where it ends.
+func (p *printer) list(open string, items []ast.Expression, close string, openTok, closeTok token.Token, item func(ast.Expression) token.Position) {
+	p.write(open)
+	if len(items) == 0 || items[0].Pos().Line == openTok.Pos.Line {
+		for i, it := range items {
+			if i > 0 {
+				p.write(", ")
+			}
+			item(it)
+		}
+		p.write(close)
+		return
+	}
+
+	p.newline()
+	p.indent++
+	p.lastLine = 0
+	for i, it := range items {
+		p.leadingComments(it.Pos().Offset)
+		p.blankLine(it.Pos().Line)
+		end := item(it)
+		limit := closeTok.Pos.Offset
+		if i+1 < len(items) {
+			p.write(",")
+			limit = items[i+1].Pos().Offset
+		}
+		p.lastLine = end.Line
+		p.trailingComments(end, limit)
+		p.newline()
+	}
+	p.leadingComments(closeTok.Pos.Offset)
+	p.indent--
+	p.write(close)
+}
+
+func (p *printer) listItem(item ast.Expression) token.Position {
+	p.expr(item, parser.LOWEST)
+	return item.End()
+}
+
+func (p *printer) stringLiteral(str *ast.StringLiteral) {
+	if offset := str.Token.Pos.Offset; offset < len(p.src) && p.src[offset] == '`' {
+		p.write("`" + str.Value + "`")
+		return
+	}
+	p.write(`"` + escape(str.Value) + `"`)
+}
+
+func (p *printer) interpolatedString(str *ast.InterpolatedString) {
+	p.write(`"`)
+	for _, part := range str.Parts {
+		if text, ok := part.(*ast.StringLiteral); ok && text.Token.Type != token.STRING {
+			p.write(escape(text.Value))
+			continue
+		}
+		p.write("${")
+		p.expr(part, parser.LOWEST)
+		p.write("}")
+	}
+	p.write(`"`)
+}
+
+// escape escapes s for a double quoted string.
+func escape(s string) string {
+	var out strings.Builder
+	for i, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case 0:
+			out.WriteString(`\0`)
+		case '$':
+			if strings.HasPrefix(s[i+1:], "{") {
+				out.WriteString(`\$`)
+			} else {
+				out.WriteRune(r)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				out.WriteRune(r)
+			} else {
+				fmt.Fprintf(&out, `\u{%x}`, r)
+			}
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,123 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let   x=1", "let x = 1;\n"},
+		{"let x = 1;\n\n\n\nlet y = 2", "let x = 1;\n\nlet y = 2;\n"},
+		{"\n\nx\n\n", "x;\n"},
+		{"let add = fn(a,b){a+b}", "let add = fn(a, b) { a + b };\n"},
+		{"let f = fn(x) {\nreturn x\n}", "let f = fn(x) {\n\treturn x;\n};\n"},
+		{"let f = fn() {\n\n  let a = 1\n\n\n  a\n\n}", "let f = fn() {\n\tlet a = 1;\n\n\ta;\n};\n"},
+		{"fn() {}", "fn() {};\n"},
+		{"fn() {\n}", "fn() {\n};\n"},
+		// parentheses
+		{"(1 + 2) * 3", "(1 + 2) * 3;\n"},
+		{"((1 * 2)) + 3", "1 * 2 + 3;\n"},
+		{"a - (b - c)", "a - (b - c);\n"},
+		{"(a - b) - c", "a - b - c;\n"},
+		{"-(a + b)", "-(a + b);\n"},
+		{"-(-a)", "--a;\n"},
+		{"!(a && b) || c", "!(a && b) || c;\n"},
+		{"a || (b && c)", "a || b && c;\n"},
+		{"a = (b = c)", "a = b = c;\n"},
+		{"x += (1 + 2)", "x += 1 + 2;\n"},
+		{"(f(x))[0]", "f(x)[0];\n"},
+		{"(a[0])(1)", "a[0](1);\n"},
+		{"-(f(x))", "-f(x);\n"},
+		{"(-a)[0]", "(-a)[0];\n"},
+		{"(a + b)(c)", "(a + b)(c);\n"},
+		{"fn(x) { x }(1)", "(fn(x) { x })(1);\n"},
+		// statements
+		{"while(x<3){x+=1}\nfor(k,v in h){puts(k)}", "while (x < 3) { x += 1 }\nfor (k, v in h) { puts(k) }\n"},
+		{"for (c in \"ab\") { if (c == \"a\") { continue }; break }", "for (c in \"ab\") { if (c == \"a\") { continue }; break }\n"},
+		{"if (a) { 1 } else { 2 }\nb", "if (a) { 1 } else { 2 }\nb;\n"},
+		{"if (a) { 1 };\n(b)", "if (a) { 1 }\nb;\n"},
+		{"if (a) { 1 };\n(b)(c)", "if (a) { 1 }\nb(c);\n"},
+		{"if (a) { 1 };\n(b + c) * 2", "if (a) { 1 };\n(b + c) * 2;\n"},
+		{"if (a) { 1 };\n[1]", "if (a) { 1 };\n[1];\n"},
+		{"if (a) { 1 };\n-1", "if (a) { 1 };\n-1;\n"},
+		{"if (a) { 1 }\nlet b = 1", "if (a) { 1 }\nlet b = 1;\n"},
+		// literals
+		{"[1,2,3]", "[1, 2, 3];\n"},
+		{"{\"a\":1,\"b\":2,}", "{\"a\": 1, \"b\": 2};\n"},
+		{"{}", "{};\n"},
+		{"[\n1,\n2]", "[\n\t1,\n\t2\n];\n"},
+		{"{\n\"a\": 1,\n\"b\": fn(x) {\nx\n},\n}", "{\n\t\"a\": 1,\n\t\"b\": fn(x) {\n\t\tx;\n\t}\n};\n"},
+		{"f(a,\n  b)", "f(a, b);\n"},
+		{"f(\n  a,\n  b\n)", "f(\n\ta,\n\tb\n);\n"},
+		{"1.50 + 0x", "1.50 + 0;\nx;\n"},
+		// strings
+		{`"a\"b\\c\n\t\r\0"`, `"a\"b\\c\n\t\r\0";` + "\n"},
+		{`"é\u{1F600}\u0001"`, `"é😀\u{1}";` + "\n"},
+		{`"$ \${x}"`, `"$ \${x}";` + "\n"},
+		{"`raw\\n ${x}`", "`raw\\n ${x}`;\n"},
+		{`"a ${x+1} b ${ "c" } d"`, `"a ${x + 1} b ${"c"} d";` + "\n"},
+		{`"${x}"`, `"${x}";` + "\n"},
+		// comments
+		{"// a\n// b\nlet a = 1 // c\n/* d */ let b = 2", "// a\n// b\nlet a = 1; // c\n/* d */\nlet b = 2;\n"},
+		{"// a\n\n// b\n\n\nx", "// a\n\n// b\n\nx;\n"},
+		{"x /* a */ /* b */\ny", "x; /* a */ /* b */\ny;\n"},
+		{"x // a\n// b\ny", "x; // a\n// b\ny;\n"},
+		{"let a = 1 + /* one */ 2", "let a = 1 + 2; /* one */\n"},
+		{"let f = fn() { // f\n  a\n  // end\n}", "let f = fn() {\n\t// f\n\ta;\n\t// end\n};\n"},
+		{"let f = fn() { a /* b */ }", "let f = fn() {\n\ta; /* b */\n};\n"},
+		{"[\n  1, // one\n  // two\n  2\n  // end\n]", "[\n\t1, // one\n\t// two\n\t2\n\t// end\n];\n"},
+		{"x\n// end\n\n/* really */", "x;\n// end\n\n/* really */\n"},
+		{"// only", "// only\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.input, err)
+			continue
+		}
+		if string(got) != tt.expected {
+			t.Errorf("wrong output for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+			continue
+		}
+
+		again, err := Source(got)
+		if err != nil {
+			t.Errorf("formatted %q does not parse: %s", got, err)
+			continue
+		}
+		if string(again) != string(got) {
+			t.Errorf("formatting %q again changed it to %q", got, again)
+		}
+		if parse(t, tt.input) != parse(t, string(got)) {
+			t.Errorf("formatting %q changed its meaning to %q", tt.input, got)
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := Source([]byte("let = 1\nlet x = ;"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	syntaxErr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("error is not a *SyntaxError. got=%T", err)
+	}
+	if len(syntaxErr.Diagnostics) != 2 || strings.Count(err.Error(), "\n") != 1 {
+		t.Errorf("wrong diagnostics: %s", err)
+	}
+}
+
+func parse(t *testing.T, src string) string {
+	t.Helper()
+	return parser.New(lexer.New(src)).ParseProgram().String()
+}
@@ -1,12 +1,38 @@
 package lexer
 
 import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/startdusk/tinyscript/token"
 )
 
-func New(input string) *Lexer {
+// Option configures a Lexer.
+type Option func(*Lexer)
+
+// WithFilename sets the filename reported in token positions.
+func WithFilename(filename string) Option {
+	return func(l *Lexer) {
+		l.filename = filename
+	}
+}
+
+// WithComments makes the lexer emit comments as COMMENT tokens instead of
+// skipping them.
+func WithComments() Option {
+	return func(l *Lexer) {
+		l.emitComments = true
+	}
+}
+
+func New(input string, opts ...Option) *Lexer {
 	l := Lexer{
 		input: input,
+		line:  1,
+	}
+	for _, opt := range opts {
+		opt(&l)
 	}
 	l.readChar()
 	return &l
@@ -14,14 +40,36 @@ func New(input string) *Lexer {
 
 type Lexer struct {
 	input        string
-	position     int  // current position in input (points to current char)
-	readPosition int  // current reading position in input (after current char)
-	ch           byte // current char under examination
+	position     int  // current byte offset in input (points to current char)
+	readPosition int  // current reading offset in input (after current char)
+	ch           rune // current char under examination
+
+	filename     string
+	emitComments bool
+	interps      []int // brace depth within each open ${} of a string
+	line         int   // line of the current char
+	column       int   // column of the current char
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
 	l.skipWhitespace()
+	pos := l.pos()
+	tok := l.nextToken()
+	if tok.Pos.IsValid() {
+		// the token reports a part of the source, e.g. an invalid escape
+		return tok
+	}
+	tok.Pos = pos
+	if tok.Type == token.EOF {
+		tok.End = pos
+	} else {
+		tok.End = l.pos()
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -40,15 +88,25 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newOperator(token.MINUS, token.MINUS_ASSIGN)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.atComment() {
+			tok.Literal, tok.Type = l.readComment()
+			return tok
+		}
+		tok = l.newOperator(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.newOperator(token.ASTERISK, token.ASTERISK_ASSIGN)
+	case '%':
+		tok = l.newOperator(token.PERCENT, token.PERCENT_ASSIGN)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.newOperator(token.LT, token.LT_EQ)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.newOperator(token.GT, token.GT_EQ)
+	case '&':
+		tok = l.newPair(token.AND)
+	case '|':
+		tok = l.newPair(token.OR)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -58,14 +116,25 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newOperator(token.PLUS, token.PLUS_ASSIGN)
 	case '{':
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1]++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interps); n > 0 {
+			if l.interps[n-1] == 0 {
+				l.interps = l.interps[:n-1]
+				return l.readString(token.INTERP_MIDDLE, token.INTERP_END)
+			}
+			l.interps[n-1]--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		return l.readString(token.INTERP_START, token.STRING)
+	case '`':
+		return l.readRawString()
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -81,8 +150,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Type, tok.Literal = l.readNumber()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -93,65 +161,316 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
-func (l *Lexer) readString() string {
-	pos := l.position + 1
-	for {
+// readString reads a double quoted string, which may not span lines, and
+// decodes its escape sequences. It starts at the opening '"', or at the '}'
+// closing an interpolation, and stops after the closing '"', in which case
+// the token is of type closed, or after a "${", in which case it is of type
+// open. An unterminated string is ILLEGAL, and so is an invalid escape
+// sequence, reported on its own after skipping the string.
+func (l *Lexer) readString(open, closed token.TokenType) token.Token {
+	start := l.position
+	var out strings.Builder
+	var bad *token.Token
+	l.readChar()
+	for l.ch != '"' {
+		switch l.ch {
+		case 0, '\n':
+			return token.Token{Type: token.ILLEGAL, Literal: "\"" + l.input[start+1:l.position]}
+		case '$':
+			if l.peekChar() != '{' {
+				out.WriteRune(l.ch)
+				l.readChar()
+				continue
+			}
+			l.readChar()
+			l.readChar()
+			l.interps = append(l.interps, 0)
+			if bad != nil {
+				return *bad
+			}
+			return token.Token{Type: open, Literal: out.String()}
+		case '\\':
+			pos, from := l.pos(), l.position
+			r, ok := l.readEscape()
+			if !ok && bad == nil {
+				bad = &token.Token{Type: token.ILLEGAL, Literal: l.input[from:l.position], Pos: pos, End: l.pos()}
+			}
+			out.WriteRune(r)
+		default:
+			out.WriteRune(l.ch)
+			l.readChar()
+		}
+	}
+	l.readChar()
+	if bad != nil {
+		return *bad
+	}
+	return token.Token{Type: closed, Literal: out.String()}
+}
+
+// readEscape decodes the escape sequence starting at the current
+// backslash and moves past it.
+func (l *Lexer) readEscape() (rune, bool) {
+	l.readChar()
+	switch ch := l.ch; ch {
+	case 'n', 't', 'r', '0', '"', '\\', '$':
+		l.readChar()
+		return escapes[ch], true
+	case 'u':
+		l.readChar()
+		return l.readUnicodeEscape()
+	case 0, '\n':
+		// left for readString to report as unterminated
+		return 0, false
+	default:
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		return 0, false
+	}
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'"':  '"',
+	'\\': '\\',
+	'$':  '$',
+}
+
+// readUnicodeEscape reads the code point of a \uXXXX or \u{X...} escape.
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	braced := l.ch == '{'
+	if braced {
+		l.readChar()
+	}
+	var r rune
+	n := 0
+	for isHexDigit(l.ch) && (braced && n < 6 || !braced && n < 4) {
+		r = r<<4 | hexValue(l.ch)
+		n++
+		l.readChar()
+	}
+	if braced {
+		if l.ch != '}' || n == 0 {
+			return 0, false
 		}
+		l.readChar()
+	} else if n < 4 {
+		return 0, false
 	}
-	return l.input[pos:l.position]
+	if !utf8.ValidRune(r) {
+		return 0, false
+	}
+	return r, true
+}
+
+// readRawString reads a backtick quoted string, which may span lines and
+// has no escape sequences. An unterminated one is ILLEGAL.
+func (l *Lexer) readRawString() token.Token {
+	start := l.position
+	l.readChar()
+	for l.ch != '`' {
+		if l.ch == 0 {
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[start:l.position]}
+		}
+		l.readChar()
+	}
+	l.readChar()
+	// like Go, carriage returns are dropped from raw strings
+	lit := strings.ReplaceAll(l.input[start+1:l.position-1], "\r", "")
+	return token.Token{Type: token.STRING, Literal: lit}
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	if l.readPosition <= len(l.input) {
+		l.column++
+	}
+	l.position = l.readPosition
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+		l.readPosition = len(l.input) + 1
+		return
 	}
-	l.position = l.readPosition
-	l.readPosition++
+	r, width := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	l.ch = r
+	l.readPosition += width
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
-		return 0
-	} else {
-		return l.input[l.readPosition]
+// pos returns the position of the current char.
+func (l *Lexer) pos() token.Position {
+	offset := l.position
+	if offset > len(l.input) {
+		offset = len(l.input)
+	}
+	return token.Position{
+		Filename: l.filename,
+		Offset:   offset,
+		Line:     l.line,
+		Column:   l.column,
 	}
 }
 
+func (l *Lexer) peekChar() rune {
+	return l.peekCharAt(0)
+}
+
+// peekCharAt returns the char n positions after the next one.
+func (l *Lexer) peekCharAt(n int) rune {
+	offset := l.readPosition
+	for ; n >= 0; n-- {
+		if offset >= len(l.input) {
+			return 0
+		}
+		r, width := utf8.DecodeRuneInString(l.input[offset:])
+		if n == 0 {
+			return r
+		}
+		offset += width
+	}
+	return 0
+}
+
+// readIdentifier reads a letter followed by letters and digits. Letters
+// are Unicode letters and '_'.
 func (l *Lexer) readIdentifier() string {
 	pos := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || isDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[pos:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a float such as 3.14, 2.5e3 or 1e-9.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	pos := l.position
+	var typ token.TokenType = token.INT
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		typ = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekCharAt(0)
+		if isDigit(next) || (next == '+' || next == '-') && isDigit(l.peekCharAt(1)) {
+			typ = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return typ, l.input[pos:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
 }
 
+// skipWhitespace skips whitespace and, unless they are emitted as tokens,
+// comments. An unterminated block comment is left for nextToken to report.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case !l.emitComments && l.atComment() && l.commentClosed():
+			l.readComment()
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) atComment() bool {
+	return l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')
+}
+
+func (l *Lexer) commentClosed() bool {
+	return l.peekChar() == '/' || strings.Contains(l.input[l.position+2:], "*/")
+}
+
+// readComment reads a // line comment, without the line break, or a
+// /* block */ comment. A block comment that is never closed is ILLEGAL.
+func (l *Lexer) readComment() (string, token.TokenType) {
+	pos := l.position
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[pos:l.position], token.COMMENT
+	}
+
+	if !l.commentClosed() {
+		for l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[pos:], token.ILLEGAL
+	}
+	l.readChar()
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
 		l.readChar()
 	}
+	l.readChar()
+	l.readChar()
+	return l.input[pos:l.position], token.COMMENT
 }
 
-func isLetter(ch byte) bool {
-	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || (ch == '_')
+func isLetter(ch rune) bool {
+	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || (ch == '_') ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+func hexValue(ch rune) rune {
+	switch {
+	case isDigit(ch):
+		return ch - '0'
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10
+	default:
+		return ch - 'A' + 10
+	}
+}
+
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
+
+// newOperator returns a token of type t for the current character, or of
+// type withEq if it is directly followed by '=', as in "+=" or "<=".
+func (l *Lexer) newOperator(t, withEq token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: withEq, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(t, l.ch)
+}
+
+// newPair returns a token of type t if the current character is doubled,
+// as in "&&", and an ILLEGAL token otherwise.
+func (l *Lexer) newPair(t token.TokenType) token.Token {
+	if l.peekChar() == l.ch {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: t, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(token.ILLEGAL, l.ch)
+}
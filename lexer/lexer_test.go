@@ -42,6 +42,93 @@ func TestNextToken(t *testing.T) {
 				{token.EOF, ""},
 			},
 		},
+		{
+			name:  "compound assignment",
+			input: "x += 1; x -= 2; x *= 3; x /= 4; x %= 5 % 6",
+			expects: []expect{
+				{token.IDENT, "x"},
+				{token.PLUS_ASSIGN, "+="},
+				{token.INT, "1"},
+				{token.SEMICOLON, ";"},
+				{token.IDENT, "x"},
+				{token.MINUS_ASSIGN, "-="},
+				{token.INT, "2"},
+				{token.SEMICOLON, ";"},
+				{token.IDENT, "x"},
+				{token.ASTERISK_ASSIGN, "*="},
+				{token.INT, "3"},
+				{token.SEMICOLON, ";"},
+				{token.IDENT, "x"},
+				{token.SLASH_ASSIGN, "/="},
+				{token.INT, "4"},
+				{token.SEMICOLON, ";"},
+				{token.IDENT, "x"},
+				{token.PERCENT_ASSIGN, "%="},
+				{token.INT, "5"},
+				{token.PERCENT, "%"},
+				{token.INT, "6"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			name:  "numbers",
+			input: "3.14 1e-9 2.5E+3 7 1.foo 2e",
+			expects: []expect{
+				{token.FLOAT, "3.14"},
+				{token.FLOAT, "1e-9"},
+				{token.FLOAT, "2.5E+3"},
+				{token.INT, "7"},
+				{token.INT, "1"},
+				{token.ILLEGAL, "."},
+				{token.IDENT, "foo"},
+				{token.INT, "2"},
+				{token.IDENT, "e"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			name:  "logical and comparison",
+			input: "a <= b >= c && d || e & |",
+			expects: []expect{
+				{token.IDENT, "a"},
+				{token.LT_EQ, "<="},
+				{token.IDENT, "b"},
+				{token.GT_EQ, ">="},
+				{token.IDENT, "c"},
+				{token.AND, "&&"},
+				{token.IDENT, "d"},
+				{token.OR, "||"},
+				{token.IDENT, "e"},
+				{token.ILLEGAL, "&"},
+				{token.ILLEGAL, "|"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			name:  "comments",
+			input: "a // line comment\n/* block\n comment */ b /**/ / c /* unterminated",
+			expects: []expect{
+				{token.IDENT, "a"},
+				{token.IDENT, "b"},
+				{token.SLASH, "/"},
+				{token.IDENT, "c"},
+				{token.ILLEGAL, "/* unterminated"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			name:  "loops",
+			input: "while for x in break continue",
+			expects: []expect{
+				{token.WHILE, "while"},
+				{token.FOR, "for"},
+				{token.IDENT, "x"},
+				{token.IN, "in"},
+				{token.BREAK, "break"},
+				{token.CONTINUE, "continue"},
+				{token.EOF, ""},
+			},
+		},
 		{
 			name: "complex1",
 			input: `
@@ -150,7 +237,7 @@ func TestNextToken(t *testing.T) {
 			x + y;
 			};
 			let result = add(five, ten);
-			!-/*5;
+			!-/ *5;
 			5 < 10 > 5;
 			`,
 			expects: []expect{
@@ -204,6 +291,67 @@ func TestNextToken(t *testing.T) {
 				{token.SEMICOLON, ";"},
 			},
 		},
+		{
+			name:  "strings",
+			input: `"a\tb\n" "say \"hi\" \\" "caf\u00e9 \u{1F600}" "é" ` + "`raw\\n\nline`" + ` ""`,
+			expects: []expect{
+				{token.STRING, "a\tb\n"},
+				{token.STRING, `say "hi" \`},
+				{token.STRING, "café 😀"},
+				{token.STRING, "é"},
+				{token.STRING, "raw\\n\nline"},
+				{token.STRING, ""},
+			},
+		},
+		{
+			name:  "bad strings",
+			input: "\"a\\qb\" 1 \"\\u12\" \"open\nx `never closed",
+			expects: []expect{
+				{token.ILLEGAL, `\q`},
+				{token.INT, "1"},
+				{token.ILLEGAL, `\u12`},
+				{token.ILLEGAL, `"open`},
+				{token.IDENT, "x"},
+				{token.ILLEGAL, "`never closed"},
+			},
+		},
+		{
+			name:  "interpolation",
+			input: `"a ${x} b ${f("}", {1: "${y}"})}" "\${x}" "$5"`,
+			expects: []expect{
+				{token.INTERP_START, "a "},
+				{token.IDENT, "x"},
+				{token.INTERP_MIDDLE, " b "},
+				{token.IDENT, "f"},
+				{token.LPAREN, "("},
+				{token.STRING, "}"},
+				{token.COMMA, ","},
+				{token.LBRACE, "{"},
+				{token.INT, "1"},
+				{token.COLON, ":"},
+				{token.INTERP_START, ""},
+				{token.IDENT, "y"},
+				{token.INTERP_END, ""},
+				{token.RBRACE, "}"},
+				{token.RPAREN, ")"},
+				{token.INTERP_END, ""},
+				{token.STRING, "${x}"},
+				{token.STRING, "$5"},
+			},
+		},
+		{
+			name:  "unicode identifiers",
+			input: "let café = größe1 + _x2;",
+			expects: []expect{
+				{token.LET, "let"},
+				{token.IDENT, "café"},
+				{token.ASSIGN, "="},
+				{token.IDENT, "größe1"},
+				{token.PLUS, "+"},
+				{token.IDENT, "_x2"},
+				{token.SEMICOLON, ";"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -227,3 +375,111 @@ func TestNextToken(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  add(x, \"hi\");"
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  string
+		expectedEnd  string
+	}{
+		{token.LET, "test.ts:1:1", "test.ts:1:4"},
+		{token.IDENT, "test.ts:1:5", "test.ts:1:6"},
+		{token.ASSIGN, "test.ts:1:7", "test.ts:1:8"},
+		{token.INT, "test.ts:1:9", "test.ts:1:10"},
+		{token.SEMICOLON, "test.ts:1:10", "test.ts:1:11"},
+		{token.IDENT, "test.ts:2:3", "test.ts:2:6"},
+		{token.LPAREN, "test.ts:2:6", "test.ts:2:7"},
+		{token.IDENT, "test.ts:2:7", "test.ts:2:8"},
+		{token.COMMA, "test.ts:2:8", "test.ts:2:9"},
+		{token.STRING, "test.ts:2:10", "test.ts:2:14"},
+		{token.RPAREN, "test.ts:2:14", "test.ts:2:15"},
+		{token.SEMICOLON, "test.ts:2:15", "test.ts:2:16"},
+		{token.EOF, "test.ts:2:16", "test.ts:2:16"},
+	}
+
+	l := New(input, WithFilename("test.ts"))
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%q, got=%q", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End.String() != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. expected=%q, got=%q", i, tt.expectedEnd, tok.End)
+		}
+	}
+	if off := l.NextToken().Pos.Offset; off != len(input) {
+		t.Errorf("EOF offset wrong. expected=%d, got=%d", len(input), off)
+	}
+}
+
+func TestUnicodePositions(t *testing.T) {
+	input := "let π = \"ü\\x\";\n`a\nb` 😀"
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  string
+		expectedEnd  string
+	}{
+		{token.LET, "1:1", "1:4"},
+		{token.IDENT, "1:5", "1:6"},
+		{token.ASSIGN, "1:7", "1:8"},
+		{token.ILLEGAL, "1:11", "1:13"},
+		{token.SEMICOLON, "1:14", "1:15"},
+		{token.STRING, "2:1", "3:3"},
+		{token.ILLEGAL, "3:4", "3:5"},
+		{token.EOF, "3:5", "3:5"},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%q, got=%q", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End.String() != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. expected=%q, got=%q", i, tt.expectedEnd, tok.End)
+		}
+	}
+	if off := l.NextToken().Pos.Offset; off != len(input) {
+		t.Errorf("EOF offset wrong. expected=%d, got=%d", len(input), off)
+	}
+}
+
+func TestCommentTokens(t *testing.T) {
+	input := "// doc\nlet x = 1; /* a\nb */\n//"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedPos     string
+	}{
+		{token.COMMENT, "// doc", "1:1"},
+		{token.LET, "let", "2:1"},
+		{token.IDENT, "x", "2:5"},
+		{token.ASSIGN, "=", "2:7"},
+		{token.INT, "1", "2:9"},
+		{token.SEMICOLON, ";", "2:10"},
+		{token.COMMENT, "/* a\nb */", "2:12"},
+		{token.COMMENT, "//", "4:1"},
+		{token.EOF, "", "4:3"},
+	}
+
+	l := New(input, WithComments())
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%q, got=%q", i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtins are the builtin functions shared by the evaluator and the vm.
+// A builtin returns nil when it has no meaningful result; callers turn
+// that into their NULL object.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(arg.Len())}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+
+			return nil
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[length-1]
+			}
+
+			return nil
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if len(arr.Elements) > 0 {
+				newElements := make([]Object, length-1, length+1)
+				copy(newElements, arr.Elements[1:length])
+				return &Array{Elements: newElements}
+			}
+
+			return nil
+		}},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			newElements := make([]Object, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+			return &Array{Elements: newElements}
+		}},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+			return nil
+		}},
+	},
+	{
+		"exit",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1",
+					len(args))
+			}
+
+			code := 0
+			if len(args) == 1 {
+				arg, ok := args[0].(*Integer)
+				if !ok {
+					return newError("argument to `exit` must be INTEGER, got %s", args[0].Type())
+				}
+				code = int(arg.Value)
+			}
+			exit := &ExitError{Code: code}
+			return &Error{Message: exit.Error(), Err: exit}
+		}},
+	},
+	{
+		"int",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				// false for NaN, infinities and values out of the int64 range
+				if !(arg.Value >= math.MinInt64 && arg.Value < -math.MinInt64) {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+				if err != nil {
+					return newError("cannot convert %q to INTEGER", arg.Value)
+				}
+				return &Integer{Value: value}
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("cannot convert %q to FLOAT", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"keys",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			hash, err := hashArgument("keys", args[0])
+			if err != nil {
+				return err
+			}
+			keys := make([]Object, hash.Len())
+			for i, pair := range hash.Pairs() {
+				keys[i] = pair.Key
+			}
+			return &Array{Elements: keys}
+		}},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			hash, err := hashArgument("values", args[0])
+			if err != nil {
+				return err
+			}
+			values := make([]Object, hash.Len())
+			for i, pair := range hash.Pairs() {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		}},
+	},
+	{
+		"entries",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			hash, err := hashArgument("entries", args[0])
+			if err != nil {
+				return err
+			}
+			entries := make([]Object, hash.Len())
+			for i, pair := range hash.Pairs() {
+				entries[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+			}
+			return &Array{Elements: entries}
+		}},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+
+			hash, err := hashArgument("has", args[0])
+			if err != nil {
+				return err
+			}
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			_, found := hash.Get(key)
+			return &Boolean{Value: found}
+		}},
+	},
+	{
+		"delete",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+
+			hash, err := hashArgument("delete", args[0])
+			if err != nil {
+				return err
+			}
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			value, _ := hash.Delete(key)
+			return value
+		}},
+	},
+	{
+		"set",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=3",
+					len(args))
+			}
+
+			hash, err := hashArgument("set", args[0])
+			if err != nil {
+				return err
+			}
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			hash.Set(key, args[2])
+			return hash
+		}},
+	},
+	{
+		"merge",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) == 0 {
+				return newError("wrong number of arguments. got=0, want=1 or more")
+			}
+
+			merged := &Hash{}
+			for _, arg := range args {
+				hash, err := hashArgument("merge", arg)
+				if err != nil {
+					return err
+				}
+				for _, pair := range hash.Pairs() {
+					merged.Set(pair.Key.(Hashable), pair.Value)
+				}
+			}
+			return merged
+		}},
+	},
+	{"map", &Builtin{HigherOrder: builtinMap}},
+	{"filter", &Builtin{HigherOrder: builtinFilter}},
+	{"reduce", &Builtin{HigherOrder: builtinReduce}},
+	{"each", &Builtin{HigherOrder: builtinEach}},
+	{"find", &Builtin{HigherOrder: builtinFind}},
+	{"any", &Builtin{HigherOrder: builtinAny}},
+	{"all", &Builtin{HigherOrder: builtinAll}},
+	{"sort", &Builtin{HigherOrder: builtinSort}},
+	{"reverse", &Builtin{Fn: builtinReverse}},
+	{"zip", &Builtin{Fn: builtinZip}},
+	{"range", &Builtin{Allocating: builtinRange}},
+	{"flatten", &Builtin{Allocating: builtinFlatten}},
+	{"uniq", &Builtin{Fn: builtinUniq}},
+	{"slice", &Builtin{Fn: builtinSlice}},
+	{"index_of", &Builtin{Fn: builtinIndexOf}},
+	{"join", &Builtin{Fn: builtinJoin}},
+	{"split", &Builtin{Fn: builtinSplit}},
+	{"trim", &Builtin{Fn: builtinTrim}},
+	{"upper", &Builtin{Fn: builtinUpper}},
+	{"lower", &Builtin{Fn: builtinLower}},
+	{"replace", &Builtin{Allocating: builtinReplace}},
+	{"contains", &Builtin{Fn: builtinContains}},
+	{"starts_with", &Builtin{Fn: builtinStartsWith}},
+	{"ends_with", &Builtin{Fn: builtinEndsWith}},
+	{"index", &Builtin{Fn: builtinIndex}},
+	{"substr", &Builtin{Fn: builtinSubstr}},
+	{"repeat", &Builtin{Allocating: builtinRepeat}},
+	{"pad_left", &Builtin{Allocating: builtinPadLeft}},
+	{"pad_right", &Builtin{Allocating: builtinPadRight}},
+	{"chars", &Builtin{Fn: builtinChars}},
+	{"format", &Builtin{Fn: builtinFormat}},
+}
+
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+func hashArgument(name string, arg Object) (*Hash, *Error) {
+	hash, ok := arg.(*Hash)
+	if !ok {
+		return nil, newError("argument to `%s` must be HASH, got %s", name, arg.Type())
+	}
+	return hash, nil
+}
+
+func newError(format string, a ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,570 @@
+package object
+
+import (
+	"sort"
+	"strings"
+)
+
+// The collection builtins below are registered in Builtins. Those taking a
+// function call it through the CallFunction of the engine running the
+// script, and stop at the first error it returns.
+
+func builtinMap(call CallFunction, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, err := arrayArgument("map", args[0])
+	if err != nil {
+		return err
+	}
+
+	result := make([]Object, len(arr.Elements))
+	for i, el := range arr.Elements {
+		value := call(args[1], el)
+		if isError(value) {
+			return value
+		}
+		result[i] = value
+	}
+	return &Array{Elements: result}
+}
+
+func builtinFilter(call CallFunction, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, err := arrayArgument("filter", args[0])
+	if err != nil {
+		return err
+	}
+
+	result := []Object{}
+	for _, el := range arr.Elements {
+		keep := call(args[1], el)
+		if isError(keep) {
+			return keep
+		}
+		if truthy(keep) {
+			result = append(result, el)
+		}
+	}
+	return &Array{Elements: result}
+}
+
+// builtinReduce folds the elements into an accumulator, starting with the
+// initial value if given and with the first element otherwise.
+func builtinReduce(call CallFunction, args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+	arr, err := arrayArgument("reduce", args[0])
+	if err != nil {
+		return err
+	}
+
+	elements := arr.Elements
+	var acc Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else if len(elements) > 0 {
+		acc, elements = elements[0], elements[1:]
+	}
+	for _, el := range elements {
+		acc = call(args[1], acc, el)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func builtinEach(call CallFunction, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, err := arrayArgument("each", args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, el := range arr.Elements {
+		if result := call(args[1], el); isError(result) {
+			return result
+		}
+	}
+	return nil
+}
+
+func builtinFind(call CallFunction, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, err := arrayArgument("find", args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, el := range arr.Elements {
+		found := call(args[1], el)
+		if isError(found) {
+			return found
+		}
+		if truthy(found) {
+			return el
+		}
+	}
+	return nil
+}
+
+// builtinAny and builtinAll test the elements with the function if one is
+// given, and the elements themselves otherwise.
+func builtinAny(call CallFunction, args ...Object) Object {
+	return quantify("any", true, call, args)
+}
+
+func builtinAll(call CallFunction, args ...Object) Object {
+	return quantify("all", false, call, args)
+}
+
+// quantify reports whether an element passes the test if want is true, or
+// whether all of them do if want is false.
+func quantify(name string, want bool, call CallFunction, args []Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, err := arrayArgument(name, args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, el := range arr.Elements {
+		test := el
+		if len(args) == 2 {
+			test = call(args[1], el)
+			if isError(test) {
+				return test
+			}
+		}
+		if truthy(test) == want {
+			return &Boolean{Value: want}
+		}
+	}
+	return &Boolean{Value: !want}
+}
+
+// builtinSort returns the elements sorted, stably. Without a comparator,
+// numbers and strings are sorted in ascending order. A comparator is
+// called with two elements and returns whether the first one goes before
+// the second one, either as a boolean or as an integer less than zero.
+func builtinSort(call CallFunction, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, err := arrayArgument("sort", args[0])
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]Object, len(arr.Elements))
+	copy(sorted, arr.Elements)
+	var failed Object
+	less := func(a, b Object) bool {
+		if len(args) == 1 {
+			order, err := compare(a, b)
+			if err != nil {
+				failed = err
+			}
+			return order < 0
+		}
+		switch result := call(args[1], a, b).(type) {
+		case *Error:
+			failed = result
+		case *Boolean:
+			return result.Value
+		case *Integer:
+			return result.Value < 0
+		default:
+			failed = newError("comparator must return BOOLEAN or INTEGER, got %s", result.Type())
+		}
+		return false
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return failed == nil && less(sorted[i], sorted[j])
+	})
+	if failed != nil {
+		return failed
+	}
+	return &Array{Elements: sorted}
+}
+
+// compare orders numbers and strings.
+func compare(a, b Object) (int, *Error) {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return compareValues(a.Value, b.Value), nil
+		case *Float:
+			return compareValues(float64(a.Value), b.Value), nil
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return compareValues(a.Value, float64(b.Value)), nil
+		case *Float:
+			return compareValues(a.Value, b.Value), nil
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return strings.Compare(a.Value, b.Value), nil
+		}
+	}
+	return 0, newError("cannot compare %s and %s", a.Type(), b.Type())
+}
+
+func compareValues[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func builtinReverse(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *Array:
+		reversed := make([]Object, len(arg.Elements))
+		for i, el := range arg.Elements {
+			reversed[len(reversed)-1-i] = el
+		}
+		return &Array{Elements: reversed}
+	case *String:
+		runes := []rune(arg.Value)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return &String{Value: string(runes)}
+	default:
+		return newError("argument to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
+	}
+}
+
+// builtinZip pairs up the elements of arrays at the same index, up to the
+// length of the shortest one.
+func builtinZip(args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want=1 or more")
+	}
+
+	arrays := make([]*Array, len(args))
+	length := -1
+	for i, arg := range args {
+		arr, err := arrayArgument("zip", arg)
+		if err != nil {
+			return err
+		}
+		arrays[i] = arr
+		if length < 0 || len(arr.Elements) < length {
+			length = len(arr.Elements)
+		}
+	}
+
+	zipped := make([]Object, length)
+	for i := range zipped {
+		tuple := make([]Object, len(arrays))
+		for j, arr := range arrays {
+			tuple[j] = arr.Elements[i]
+		}
+		zipped[i] = &Array{Elements: tuple}
+	}
+	return &Array{Elements: zipped}
+}
+
+// builtinRange returns the integers from start up to, but not including,
+// end: range(end), range(start, end) or range(start, end, step).
+func builtinRange(alloc AllocFunction, args ...Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+	}
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		n, ok := arg.(*Integer)
+		if !ok {
+			return newError("argument to `range` must be INTEGER, got %s", arg.Type())
+		}
+		bounds[i] = n.Value
+	}
+
+	start, end, step := int64(0), bounds[0], int64(1)
+	if len(bounds) > 1 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+	if step == 0 {
+		return newError("range step must not be zero")
+	}
+
+	// the distance and the count are computed in uint64, which holds the
+	// distance between any two int64 and does not overflow
+	var distance, stride uint64
+	switch {
+	case step > 0 && start < end:
+		distance, stride = uint64(end)-uint64(start), uint64(step)
+	case step < 0 && start > end:
+		distance, stride = uint64(start)-uint64(end), -uint64(step)
+	}
+	count := uint64(0)
+	if distance > 0 {
+		count = (distance-1)/stride + 1
+	}
+	if count > maxRange {
+		return newError("result of `range` too large: %d elements, at most %d", count, maxRange)
+	}
+	if err := reserve(alloc, int64(count)*ElementSize); err != nil {
+		return err
+	}
+
+	result := make([]Object, count)
+	for i := range result {
+		result[i] = &Integer{Value: int64(uint64(start) + uint64(i)*uint64(step))}
+	}
+	return &Array{Elements: result}
+}
+
+// maxRange is the most elements range returns.
+const maxRange = 1 << 24
+
+// builtinFlatten splices nested arrays into the array, depth levels deep,
+// one by default.
+func builtinFlatten(alloc AllocFunction, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, err := arrayArgument("flatten", args[0])
+	if err != nil {
+		return err
+	}
+	depth := int64(1)
+	if len(args) == 2 {
+		n, ok := args[1].(*Integer)
+		if !ok || n.Value < 0 {
+			return newError("depth of `flatten` must be a non-negative INTEGER, got %s", args[1].Inspect())
+		}
+		depth = n.Value
+	}
+
+	f := &flattening{path: make(map[*Array]bool)}
+	if err := f.count(arr, depth); err != nil {
+		return err
+	}
+	if err := reserve(alloc, int64(f.size)*ElementSize); err != nil {
+		return err
+	}
+	return &Array{Elements: flatten(make([]Object, 0, f.size), arr.Elements, depth)}
+}
+
+// maxFlatten is the most elements, nested arrays included, flatten visits.
+const maxFlatten = 1 << 24
+
+// flattening counts the elements flatten splices an array into before it
+// makes them, failing for an array holding itself and for arrays nested so
+// often that flattening them would not end.
+type flattening struct {
+	path   map[*Array]bool // the arrays being counted
+	size   int             // elements of the result
+	visits int
+}
+
+func (f *flattening) count(arr *Array, depth int64) *Error {
+	if f.path[arr] {
+		return newError("cannot flatten an ARRAY holding itself")
+	}
+	f.path[arr] = true
+	defer delete(f.path, arr)
+
+	for _, el := range arr.Elements {
+		if f.visits++; f.visits > maxFlatten {
+			return newError("result of `flatten` too large: more than %d elements", maxFlatten)
+		}
+		if nested, ok := el.(*Array); ok && depth > 0 {
+			if err := f.count(nested, depth-1); err != nil {
+				return err
+			}
+		} else {
+			f.size++
+		}
+	}
+	return nil
+}
+
+func flatten(result, elements []Object, depth int64) []Object {
+	for _, el := range elements {
+		if nested, ok := el.(*Array); ok && depth > 0 {
+			result = flatten(result, nested.Elements, depth-1)
+		} else {
+			result = append(result, el)
+		}
+	}
+	return result
+}
+
+// builtinUniq drops the elements equal to an earlier one.
+func builtinUniq(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	arr, err := arrayArgument("uniq", args[0])
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[HashKey]bool)
+	result := []Object{}
+	for _, el := range arr.Elements {
+		if key, ok := el.(Hashable); ok {
+			if seen[key.HashKey()] {
+				continue
+			}
+			seen[key.HashKey()] = true
+		} else if indexOf(result, el) >= 0 {
+			continue
+		}
+		result = append(result, el)
+	}
+	return &Array{Elements: result}
+}
+
+// builtinSlice returns the elements, or runes of a string, from start up
+// to, but not including, end, which defaults to the length. Negative
+// indexes count from the end.
+func builtinSlice(args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *Array:
+		start, end, err := sliceBounds("slice", args[1:], len(arg.Elements))
+		if err != nil {
+			return err
+		}
+		result := make([]Object, end-start)
+		copy(result, arg.Elements[start:end])
+		return &Array{Elements: result}
+	case *String:
+		runes := []rune(arg.Value)
+		start, end, err := sliceBounds("slice", args[1:], len(runes))
+		if err != nil {
+			return err
+		}
+		return &String{Value: string(runes[start:end])}
+	default:
+		return newError("argument to `slice` must be ARRAY or STRING, got %s", args[0].Type())
+	}
+}
+
+// sliceBounds returns the start and end index given by bounds for a
+// sequence of length elements, clamped to the sequence.
+func sliceBounds(name string, bounds []Object, length int) (int, int, *Error) {
+	indexes := []int{0, length}
+	for i, bound := range bounds {
+		n, ok := bound.(*Integer)
+		if !ok {
+			return 0, 0, newError("argument to `%s` must be INTEGER, got %s", name, bound.Type())
+		}
+		index := n.Value
+		if index < 0 {
+			index += int64(length)
+		}
+		if index < 0 {
+			index = 0
+		}
+		if index > int64(length) {
+			index = int64(length)
+		}
+		indexes[i] = int(index)
+	}
+	if indexes[1] < indexes[0] {
+		indexes[1] = indexes[0]
+	}
+	return indexes[0], indexes[1], nil
+}
+
+func builtinIndexOf(args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, err := arrayArgument("index_of", args[0])
+	if err != nil {
+		return err
+	}
+	return &Integer{Value: int64(indexOf(arr.Elements, args[1]))}
+}
+
+func indexOf(elements []Object, value Object) int {
+	for i, el := range elements {
+		if Equal(el, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// builtinJoin joins the elements into a string, separated by sep if given.
+func builtinJoin(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	arr, err := arrayArgument("join", args[0])
+	if err != nil {
+		return err
+	}
+	sep := ""
+	if len(args) == 2 {
+		s, ok := args[1].(*String)
+		if !ok {
+			return newError("separator of `join` must be STRING, got %s", args[1].Type())
+		}
+		sep = s.Value
+	}
+
+	parts := make([]string, len(arr.Elements))
+	for i, el := range arr.Elements {
+		parts[i] = el.Inspect()
+	}
+	return &String{Value: strings.Join(parts, sep)}
+}
+
+func arrayArgument(name string, arg Object) (*Array, *Error) {
+	arr, ok := arg.(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, arg.Type())
+	}
+	return arr, nil
+}
+
+// truthy tells how a condition is decided, like in if and while.
+func truthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return obj != nil
+	}
+}
+
+func isError(obj Object) bool {
+	_, ok := obj.(*Error)
+	return ok
+}
@@ -0,0 +1,582 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	objectType  = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	builtinType = reflect.TypeOf(BuiltinFunction(nil))
+)
+
+// FromGo converts a Go value to an Object:
+//
+//   - nil and nil pointers to NULL
+//   - integers to INTEGER and floats to FLOAT
+//   - strings to STRING and bools to BOOLEAN
+//   - slices and arrays to ARRAY
+//   - maps to HASH, with their keys in sorted order
+//   - structs to HASH with a STRING key per exported field. The key is
+//     the field name, or the name given by a `tinyscript:"name"` tag.
+//     Fields tagged `tinyscript:"-"` are left out.
+//   - funcs to BUILTIN functions, see below
+//
+// Pointers and interfaces are converted as the value they point to, and
+// Objects are returned as they are. A value that refers to itself cannot
+// be converted.
+//
+// A func is called with its arguments converted by ToGo, except that
+// functions of the script can be passed for func arguments. Those funcs
+// call back into the script, and must only be called before the func they
+// are passed to returns, on the same goroutine. If its last result is an
+// error, a non-nil error becomes an ERROR. The remaining results are
+// converted by FromGo, several of them into an ARRAY.
+func FromGo(v any) (Object, error) {
+	return fromValue(reflect.ValueOf(v))
+}
+
+func fromValue(v reflect.Value) (Object, error) {
+	return convertValue(v, make(map[reference]bool))
+}
+
+// reference identifies a pointer, map or slice being converted, see
+// convertValue.
+type reference struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// convertValue converts v, where visiting holds the pointers, maps and
+// slices converted further up, so that values referring to themselves
+// fail instead of recursing forever.
+func convertValue(v reflect.Value, visiting map[reference]bool) (Object, error) {
+	if !v.IsValid() {
+		return &Null{}, nil
+	}
+	if v.Type().Implements(objectType) && (v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface || !v.IsNil()) {
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			ref := reference{ptr: v.Pointer(), typ: v.Type()}
+			if v.Kind() == reflect.Slice {
+				ref.len = v.Len()
+			}
+			if visiting[ref] {
+				return nil, fmt.Errorf("cannot convert cyclic Go value of type %s", v.Type())
+			}
+			visiting[ref] = true
+			defer delete(visiting, ref)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		return convertValue(v.Elem(), visiting)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Bool:
+		return &Boolean{Value: v.Bool()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return &Null{}, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			elem, err := convertValue(v.Index(i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+		hash := &Hash{}
+		for _, k := range keys {
+			key, err := convertValue(k, visiting)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := convertValue(v.MapIndex(k), visiting)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashable, value)
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &Hash{}
+		for _, f := range structFields(v.Type()) {
+			value, err := convertValue(v.Field(f.index), visiting)
+			if err != nil {
+				return nil, err
+			}
+			key := &String{Value: f.name}
+			hash.Set(key, value)
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		if v.Type().ConvertibleTo(builtinType) {
+			return &Builtin{Fn: v.Convert(builtinType).Interface().(BuiltinFunction)}, nil
+		}
+		return &Builtin{HigherOrder: wrapFunc(v)}, nil
+	default:
+		return nil, fmt.Errorf("cannot convert Go value of type %s", v.Type())
+	}
+}
+
+// keyLess orders map keys for FromGo, numerically for numbers.
+func keyLess(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	default:
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+}
+
+type structField struct {
+	name  string
+	index int
+}
+
+// structFields returns the exported fields of t that are not tagged
+// `tinyscript:"-"`, with their names in scripts.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("tinyscript"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag, _, _ = strings.Cut(tag, ","); tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: i})
+	}
+	return fields
+}
+
+// wrapFunc makes fn callable as a builtin function, which passes the
+// functions of the script in its arguments as funcs calling them through
+// call.
+func wrapFunc(fn reflect.Value) HigherOrderFunction {
+	t := fn.Type()
+	return func(call CallFunction, args ...Object) Object {
+		numIn := t.NumIn()
+		if t.IsVariadic() && len(args) < numIn-1 {
+			return newError("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+		}
+		if !t.IsVariadic() && len(args) != numIn {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var argType reflect.Type
+			if t.IsVariadic() && i >= numIn-1 {
+				argType = t.In(numIn - 1).Elem()
+			} else {
+				argType = t.In(i)
+			}
+			in[i] = reflect.New(argType).Elem()
+			if err := newConverter(call).convert(arg, in[i]); err != nil {
+				return newError("argument %d: %s", i+1, err)
+			}
+		}
+
+		out := fn.Call(in)
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1]; !err.IsNil() {
+				return newError("%s", err.Interface().(error))
+			}
+			out = out[:n-1]
+		}
+
+		results := make([]Object, len(out))
+		for i, v := range out {
+			result, err := fromValue(v)
+			if err != nil {
+				return newError("result %d: %s", i+1, err)
+			}
+			results[i] = result
+		}
+		switch len(results) {
+		case 0:
+			return nil
+		case 1:
+			return results[0]
+		default:
+			return &Array{Elements: results}
+		}
+	}
+}
+
+// ToGo stores obj in the Go value target points to, converting it the
+// opposite way FromGo does. An INTEGER also converts to a float, a HASH
+// to a struct by the names of its fields and a BUILTIN to a func. Into an
+// interface, INTEGER converts to int64, FLOAT to float64, ARRAY to []any,
+// HASH to map[string]any, or map[any]any if it has other than STRING
+// keys, and functions stay Objects. An ARRAY or HASH holding itself cannot
+// be converted.
+func ToGo(obj Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("ToGo target must be a non-nil pointer, got %T", target)
+	}
+	return newConverter(nil).convert(obj, v.Elem())
+}
+
+// converter converts Objects into Go values.
+type converter struct {
+	call CallFunction    // for functions of the script, nil outside of one
+	seen map[Object]bool // the arrays and hashes being converted further up
+}
+
+func newConverter(call CallFunction) *converter {
+	return &converter{call: call, seen: make(map[Object]bool)}
+}
+
+// convert converts obj into v.
+func (c *converter) convert(obj Object, v reflect.Value) error {
+	if obj == nil {
+		obj = &Null{}
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		natural, err := c.natural(obj)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+		return nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		if _, ok := obj.(*Null); ok {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		elem := reflect.New(t.Elem())
+		if err := c.convert(obj, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			if v.OverflowInt(i.Value) {
+				return fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *Float:
+			v.SetFloat(n.Value)
+			return nil
+		case *Integer:
+			v.SetFloat(float64(n.Value))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			v.SetString(s.Value)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			v.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Slice:
+		switch arr := obj.(type) {
+		case *Null:
+			v.Set(reflect.Zero(t))
+			return nil
+		case *Array:
+			if err := c.enter(arr); err != nil {
+				return err
+			}
+			defer delete(c.seen, arr)
+			slice := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for i, elem := range arr.Elements {
+				if err := c.convert(elem, slice.Index(i)); err != nil {
+					return err
+				}
+			}
+			v.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*Array); ok {
+			if err := c.enter(arr); err != nil {
+				return err
+			}
+			defer delete(c.seen, arr)
+			if len(arr.Elements) != v.Len() {
+				return fmt.Errorf("cannot convert ARRAY of length %d to %s", len(arr.Elements), t)
+			}
+			for i, elem := range arr.Elements {
+				if err := c.convert(elem, v.Index(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		switch hash := obj.(type) {
+		case *Null:
+			v.Set(reflect.Zero(t))
+			return nil
+		case *Hash:
+			if err := c.enter(hash); err != nil {
+				return err
+			}
+			defer delete(c.seen, hash)
+			m := reflect.MakeMapWithSize(t, hash.Len())
+			for _, pair := range hash.Pairs() {
+				key := reflect.New(t.Key()).Elem()
+				if err := c.convert(pair.Key, key); err != nil {
+					return err
+				}
+				value := reflect.New(t.Elem()).Elem()
+				if err := c.convert(pair.Value, value); err != nil {
+					return err
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if hash, ok := obj.(*Hash); ok {
+			if err := c.enter(hash); err != nil {
+				return err
+			}
+			defer delete(c.seen, hash)
+			for _, f := range structFields(t) {
+				value, ok := hash.Get(&String{Value: f.name})
+				if !ok {
+					continue
+				}
+				if err := c.convert(value, v.Field(f.index)); err != nil {
+					return fmt.Errorf("field %s: %w", f.name, err)
+				}
+			}
+			return nil
+		}
+	case reflect.Func:
+		switch obj.(type) {
+		case *Builtin:
+			call := c.call
+			if call == nil {
+				call = callFromGo
+			}
+			v.Set(unwrapFunction(obj, call, t))
+			return nil
+		case *Function, *Closure:
+			if c.call != nil {
+				v.Set(unwrapFunction(obj, c.call, t))
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+// natural returns the Go value an Object converts to in an interface.
+func (c *converter) natural(obj Object) (any, error) {
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Integer:
+		return obj.Value, nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Array:
+		if err := c.enter(obj); err != nil {
+			return nil, err
+		}
+		defer delete(c.seen, obj)
+		elements := make([]any, len(obj.Elements))
+		for i, elem := range obj.Elements {
+			natural, err := c.natural(elem)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = natural
+		}
+		return elements, nil
+	case *Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs() {
+			if pair.Key.Type() != STRING_OBJ {
+				stringKeys = false
+			}
+		}
+		var target any
+		if stringKeys {
+			target = &map[string]any{}
+		} else {
+			target = &map[any]any{}
+		}
+		if err := c.convert(obj, reflect.ValueOf(target).Elem()); err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(target).Elem().Interface(), nil
+	case *Error:
+		return nil, obj
+	default:
+		return obj, nil
+	}
+}
+
+// enter adds the array or hash obj to the ones being converted, unless it
+// is being converted further up already.
+func (c *converter) enter(obj Object) error {
+	if c.seen[obj] {
+		return fmt.Errorf("cannot convert %s holding itself", obj.Type())
+	}
+	c.seen[obj] = true
+	return nil
+}
+
+// callFromGo is how builtins called by Go code call back, which only works
+// for other builtins as no script is running.
+func callFromGo(fn Object, args ...Object) Object {
+	if b, ok := fn.(*Builtin); ok {
+		return b.Call(callFromGo, nil, args...)
+	}
+	return newError("cannot call %s outside of a script", fn.Type())
+}
+
+// unwrapFunction makes fn callable as a Go func of type t, which calls it
+// through call. If the last result of t is an error, an ERROR returned by
+// fn is returned as one.
+func unwrapFunction(fn Object, call CallFunction, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.New(t.Out(i)).Elem()
+		}
+		fail := func(err error) []reflect.Value {
+			if len(out) == 0 || t.Out(len(out)-1) != errorType {
+				panic(err)
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+
+		args := make([]Object, 0, len(in))
+		for i, v := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < v.Len(); j++ {
+					arg, err := fromValue(v.Index(j))
+					if err != nil {
+						return fail(err)
+					}
+					args = append(args, arg)
+				}
+				continue
+			}
+			arg, err := fromValue(v)
+			if err != nil {
+				return fail(err)
+			}
+			args = append(args, arg)
+		}
+
+		result := call(fn, args...)
+		if err, ok := result.(*Error); ok {
+			return fail(err)
+		}
+		if result == nil {
+			result = &Null{}
+		}
+		results := []Object{result}
+		numResults := len(out)
+		if numResults > 0 && t.Out(numResults-1) == errorType {
+			numResults--
+		}
+		if numResults > 1 {
+			arr, ok := result.(*Array)
+			if !ok || len(arr.Elements) != numResults {
+				return fail(fmt.Errorf("cannot convert %s to %d results", result.Type(), numResults))
+			}
+			results = arr.Elements
+		}
+		for i := 0; i < numResults; i++ {
+			if err := newConverter(call).convert(results[i], out[i]); err != nil {
+				return fail(err)
+			}
+		}
+		return out
+	})
+}
@@ -1,31 +1,118 @@
 package object
 
+import "sort"
+
+// Environment holds the variables of a scope. Variables live in slots,
+// which the evaluator addresses by the (depth, slot) pairs the resolver
+// assigned to identifiers. Variables bound by name, like globals defined
+// by the host, get a slot of their own as well.
+type Environment struct {
+	slots []Object
+	names map[string]int // slots of the variables bound by name
+	outer *Environment
+}
+
 func NewEncloedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	return &Environment{outer: outer}
 }
 
 func NewEnvironment() *Environment {
-	return &Environment{
-		store: make(map[string]Object),
-	}
+	return &Environment{}
 }
 
-type Environment struct {
-	store map[string]Object
-	outer *Environment
+// NewFunctionEnvironment returns an environment enclosed by outer with size
+// slots, for a call of a function with size local variables.
+func NewFunctionEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{slots: make([]Object, size), outer: outer}
 }
 
+// Get returns the value of name in the innermost environment binding it.
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.store[name]
+	for env := e; env != nil; env = env.outer {
+		if slot, ok := env.names[name]; ok && env.slots[slot] != nil {
+			return env.slots[slot], true
+		}
 	}
-	return obj, ok
+	return nil, false
 }
 
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	e.slots[e.Declare(name)] = val
 	return val
 }
+
+// Assign updates the binding of name in the innermost environment that
+// defines it. It reports false if name was never declared.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if slot, ok := env.names[name]; ok && env.slots[slot] != nil {
+			env.slots[slot] = val
+			return true
+		}
+	}
+	return false
+}
+
+// Declare returns the slot of name in e, adding an empty one if name is not
+// bound yet.
+func (e *Environment) Declare(name string) int {
+	if slot, ok := e.names[name]; ok {
+		return slot
+	}
+	if e.names == nil {
+		e.names = make(map[string]int)
+	}
+	e.names[name] = len(e.slots)
+	e.slots = append(e.slots, nil)
+	return len(e.slots) - 1
+}
+
+// Names returns the names bound to a value in e and its outer
+// environments, sorted.
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for env := e; env != nil; env = env.outer {
+		for name, slot := range env.names {
+			if env.slots[slot] != nil && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Slot returns the slot of name in e, not looking at outer environments.
+func (e *Environment) Slot(name string) (int, bool) {
+	slot, ok := e.names[name]
+	return slot, ok
+}
+
+// Load returns the value in slot of the environment depth levels out of e,
+// or nil if the slot is empty.
+func (e *Environment) Load(depth, slot int) Object {
+	env := e.at(depth)
+	if env == nil || slot >= len(env.slots) {
+		return nil
+	}
+	return env.slots[slot]
+}
+
+// Store sets slot of the environment depth levels out of e to val.
+func (e *Environment) Store(depth, slot int, val Object) {
+	env := e.at(depth)
+	for slot >= len(env.slots) {
+		env.slots = append(env.slots, nil)
+	}
+	env.slots[slot] = val
+}
+
+func (e *Environment) at(depth int) *Environment {
+	env := e
+	for ; depth > 0 && env != nil; depth-- {
+		env = env.outer
+	}
+	return env
+}
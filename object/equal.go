@@ -0,0 +1,86 @@
+package object
+
+// Equal reports whether a and b are equal values: numbers of equal value,
+// even an INTEGER and a FLOAT, strings and booleans with the same value,
+// nulls, and arrays and hashes with equal elements. Other objects, like
+// functions, are only equal to themselves.
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// visit is a pair of containers being compared, see equal.
+type visit struct {
+	a, b Object
+}
+
+// equal compares a and b, where seen holds the pairs of containers
+// compared further up, so that arrays containing themselves terminate.
+func equal(a, b Object, seen map[visit]bool) bool {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return float64(a.Value) == b.Value
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == float64(b.Value)
+		case *Float:
+			return a.Value == b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value
+		}
+	case *Boolean:
+		if b, ok := b.(*Boolean); ok {
+			return a.Value == b.Value
+		}
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		if a == b || seen[visit{a, b}] {
+			return true
+		}
+		seen = markSeen(seen, a, b)
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		if a == b || seen[visit{a, b}] {
+			return true
+		}
+		seen = markSeen(seen, a, b)
+		for _, pair := range a.Pairs() {
+			other, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !equal(pair.Value, other, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func markSeen(seen map[visit]bool, a, b Object) map[visit]bool {
+	if seen == nil {
+		seen = make(map[visit]bool)
+	}
+	seen[visit{a, b}] = true
+	return seen
+}
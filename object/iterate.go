@@ -0,0 +1,33 @@
+package object
+
+// Iterate returns the pairs a for loop visits: index and element for
+// arrays, index and character for strings, and key and value for hashes.
+// A loop with a single variable binds the second of each pair, so for it
+// a hash yields its keys as values. ok is false if obj is not iterable.
+func Iterate(obj Object, single bool) (keys, values []Object, ok bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		for i, el := range obj.Elements {
+			keys = append(keys, &Integer{Value: int64(i)})
+			values = append(values, el)
+		}
+	case *String:
+		i := 0
+		for _, r := range obj.Value {
+			keys = append(keys, &Integer{Value: int64(i)})
+			values = append(values, &String{Value: string(r)})
+			i++
+		}
+	case *Hash:
+		for _, pair := range obj.Pairs() {
+			keys = append(keys, pair.Key)
+			values = append(values, pair.Value)
+		}
+		if single {
+			values = keys
+		}
+	default:
+		return nil, nil, false
+	}
+	return keys, values, true
+}
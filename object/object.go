@@ -4,24 +4,33 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/code"
+	"github.com/startdusk/tinyscript/token"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ      ObjectType = "INTEGER"
+	FLOAT_OBJ        ObjectType = "FLOAT"
 	BOOLEAN_OBJ      ObjectType = "BOOLEAN"
 	NULL_OBJ         ObjectType = "NULL"
 	RETURN_VALUE_OBJ ObjectType = "RETURN_VALUE"
+	BREAK_OBJ        ObjectType = "BREAK"
+	CONTINUE_OBJ     ObjectType = "CONTINUE"
 	ERROR_OBJ        ObjectType = "ERROR"
 	FUNCTION_OBJ     ObjectType = "FUNCTION"
 	STRING_OBJ       ObjectType = "STRING"
 	BUILTIN_OBJ      ObjectType = "BUILTIN"
 	ARRAY_OBJ        ObjectType = "ARRAY"
 	HASH_OBJ         ObjectType = "HASH"
+
+	COMPILED_FUNCTION_OBJ ObjectType = "COMPILED_FUNCTION"
 )
 
 type Object interface {
@@ -39,6 +48,24 @@ func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+// =================================================================================================
+// Float Object
+type Float struct {
+	Value float64
+}
+
+// Inspect always shows a decimal point or an exponent, so that floats can
+// be told apart from integers.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 // =================================================================================================
 // Boolean Object
 type Boolean struct {
@@ -67,31 +94,118 @@ func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
+// =================================================================================================
+// Break Object, signals a break statement to the enclosing loop
+type Break struct{}
+
+func (b *Break) Inspect() string { return "break" }
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+// =================================================================================================
+// Continue Object, signals a continue statement to the enclosing loop
+type Continue struct{}
+
+func (c *Continue) Inspect() string { return "continue" }
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
 // =================================================================================================
 // Error Object
 type Error struct {
 	Message string
+	Trace   []Frame // call stack at the point of failure, outermost call first
+	Err     error   // Go error that caused it, if any, e.g. a cancelled context
 }
 
 func (e *Error) Inspect() string { return "ERROR: " + e.Message }
 
+// Error implements the error interface, so runtime errors can be returned
+// as Go errors, e.g. by the vm.
+func (e *Error) Error() string { return e.Message }
+
+// Unwrap returns the Go error that caused e, so that errors.Is and
+// errors.As see through it.
+func (e *Error) Unwrap() error { return e.Err }
+
+// StackTrace formats the error with its trace, most recent call last:
+//
+//	Traceback (most recent call last):
+//	  script.ts:7:1, in <main>
+//	  script.ts:3:10, in add
+//	ERROR: identifier not found: x
+//
+// Of a run of the same frame, as recursion makes, only the first
+// maxRepeatedFrames are shown.
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+	if len(e.Trace) > 0 {
+		out.WriteString("Traceback (most recent call last):\n")
+		for i := 0; i < len(e.Trace); {
+			f := e.Trace[i]
+			n := 1
+			for i+n < len(e.Trace) && e.Trace[i+n] == f {
+				n++
+			}
+			for k := 0; k < n && k < maxRepeatedFrames; k++ {
+				out.WriteString(fmt.Sprintf("  %s, in %s\n", f.Pos, f.Function))
+			}
+			if n > maxRepeatedFrames {
+				out.WriteString(fmt.Sprintf("  ... %d more calls to %s\n", n-maxRepeatedFrames, f.Function))
+			}
+			i += n
+		}
+	}
+	out.WriteString(e.Inspect())
+	return out.String()
+}
+
+// maxRepeatedFrames is the most times StackTrace repeats a frame.
+const maxRepeatedFrames = 3
+
+// ExitError is the cause of the error the exit builtin stops a script
+// with. It is up to the host to end the process with Code:
+//
+//	var exit *object.ExitError
+//	if errors.As(err, &exit) {
+//		os.Exit(exit.Code)
+//	}
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string { return fmt.Sprintf("exit status %d", e.Code) }
+
+// Frame is an entry of an error's stack trace.
+type Frame struct {
+	Function string         // function name, "<anonymous>" or "<main>"
+	Pos      token.Position // position being evaluated within Function
+}
+
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
 // =================================================================================================
 // Function Object
 type Function struct {
+	Name       string // name of the let binding the function was defined by, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
+	NumLocals  int // slots of the environment of a call, see ast.FunctionLiteral
 	Env        *Environment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 
 func (f *Function) Inspect() string {
+	return FunctionSource(f.Parameters, f.Body)
+}
+
+// FunctionSource renders a function literal, as functions are inspected.
+func FunctionSource(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 	var params []string
 
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 
@@ -99,12 +213,50 @@ func (f *Function) Inspect() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
 }
 
+// =================================================================================================
+// Compiled Function Object
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Name          string
+	LocalNames    []string // names of the locals, indexed by slot
+	Source        string   // see FunctionSource, empty for the main program
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Source != "" {
+		return cf.Source
+	}
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// =================================================================================================
+// Closure Object
+//
+// Closure is the vm's counterpart of Function, so scripts see it as a FUNCTION.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+
+func (c *Closure) Inspect() string {
+	if c.Fn.Source != "" {
+		return c.Fn.Source
+	}
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
 // =================================================================================================
 // String Object
 type String struct {
@@ -119,8 +271,56 @@ func (s *String) Inspect() string { return s.Value }
 // Builtin Function
 type BuiltinFunction func(args ...Object) Object
 
+// CallFunction calls fn, a function of the running script or a builtin,
+// with args. It returns an *Error if the call fails.
+type CallFunction func(fn Object, args ...Object) Object
+
+// HigherOrderFunction is a builtin function that calls functions passed to
+// it, like map, through call.
+type HigherOrderFunction func(call CallFunction, args ...Object) Object
+
+// AllocFunction reports an *Error if allocating size more bytes would
+// exceed the allocation budget of the running script. A nil AllocFunction
+// allows any size.
+type AllocFunction func(size int64) *Error
+
+// AllocatingFunction is a builtin function whose result can be much larger
+// than its arguments, like range. It checks the size of the result through
+// alloc before making it.
+type AllocatingFunction func(alloc AllocFunction, args ...Object) Object
+
+// Sizes of the values allocated for array elements and hash pairs, as
+// charged against allocation budgets.
+const (
+	ElementSize = 16
+	PairSize    = 64
+)
+
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn          BuiltinFunction
+	HigherOrder HigherOrderFunction // used instead of Fn if set
+	Allocating  AllocatingFunction  // used instead of Fn if set
+}
+
+// Call calls b with args. A higher-order builtin calls back through call,
+// and an allocating builtin checks its result against the budget through
+// alloc.
+func (b *Builtin) Call(call CallFunction, alloc AllocFunction, args ...Object) Object {
+	switch {
+	case b.HigherOrder != nil:
+		return b.HigherOrder(call, args...)
+	case b.Allocating != nil:
+		return b.Allocating(alloc, args...)
+	}
+	return b.Fn(args...)
+}
+
+// reserve checks the allocation of size bytes through alloc, if any.
+func reserve(alloc AllocFunction, size int64) *Error {
+	if alloc == nil {
+		return nil
+	}
+	return alloc(size)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -135,20 +335,56 @@ type Array struct {
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
 
-func (ao *Array) Inspect() string {
-	var out bytes.Buffer
-	var elements []string
+func (ao *Array) Inspect() string { return inspect(ao, nil) }
 
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+// inspect returns the Inspect of obj, where seen holds the arrays and
+// hashes being inspected further up, so that containers holding themselves
+// print as [...] or {...} instead of recursing forever.
+func inspect(obj Object, seen map[Object]bool) string {
+	var elements []string
+	var open, close string
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] {
+			return "[...]"
+		}
+		open, close = "[", "]"
+		seen = visiting(seen, obj)
+		for _, e := range obj.Elements {
+			elements = append(elements, inspect(e, seen))
+		}
+	case *Hash:
+		if seen[obj] {
+			return "{...}"
+		}
+		open, close = "{", "}"
+		seen = visiting(seen, obj)
+		for _, pair := range obj.pairs {
+			elements = append(elements, inspect(pair.Key, seen)+": "+inspect(pair.Value, seen))
+		}
+	case nil:
+		// not a value of the script, but printing must not crash the host
+		return "null"
+	default:
+		return obj.Inspect()
 	}
+	delete(seen, obj)
 
-	out.WriteString("[")
+	var out bytes.Buffer
+	out.WriteString(open)
 	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
+	out.WriteString(close)
 	return out.String()
 }
 
+func visiting(seen map[Object]bool, obj Object) map[Object]bool {
+	if seen == nil {
+		seen = make(map[Object]bool)
+	}
+	seen[obj] = true
+	return seen
+}
+
 // =================================================================================================
 // Hash
 type HashKey struct {
@@ -170,6 +406,15 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey of a float with an integral value is the one of the equal
+// integer, so that h[1] and h[1.0] refer to the same entry.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -180,25 +425,68 @@ type HashPair struct {
 	Key   Object
 	Value Object
 }
+
+// Hash maps keys to values and remembers the order in which the keys were
+// first set, which is the order Pairs and Inspect list them in. The zero
+// value is an empty hash.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	index map[HashKey]int // position of each key in pairs
+	pairs []HashPair
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-	return out.String()
+func (h *Hash) Inspect() string { return inspect(h, nil) }
+
+// Len returns the number of pairs in h.
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs of h in insertion order. The slice must not be
+// modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
+// Get returns the value of key in h.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	i, ok := h.index[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return h.pairs[i].Value, true
+}
+
+// Set sets the value of key in h. A new key is added at the end, an
+// existing one keeps its position and its original key object.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if i, ok := h.index[hashKey]; ok {
+		h.pairs[i].Value = value
+		return
+	}
+	if h.index == nil {
+		h.index = make(map[HashKey]int)
+	}
+	h.index[hashKey] = len(h.pairs)
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+// Delete removes key from h and returns its value.
+func (h *Hash) Delete(key Hashable) (Object, bool) {
+	hashKey := key.HashKey()
+	i, ok := h.index[hashKey]
+	if !ok {
+		return nil, false
+	}
+	value := h.pairs[i].Value
+	delete(h.index, hashKey)
+	h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+	for j := i; j < len(h.pairs); j++ {
+		h.index[h.pairs[j].Key.(Hashable).HashKey()] = j
+	}
+	return value, true
 }
 
+// Hashable objects can be used as hash keys.
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
@@ -1,6 +1,14 @@
 package object
 
-import "testing"
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/startdusk/tinyscript/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +25,498 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() != (&Float{Value: 1.5}).HashKey() {
+		t.Errorf("floats with same value have different hash keys")
+	}
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integral float and equal integer have different hash keys")
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("a", &Integer{Value: 1})
+	env := NewEncloedEnvironment(NewEncloedEnvironment(global))
+
+	if val, ok := env.Get("a"); !ok || val.Inspect() != "1" {
+		t.Errorf("a not found two levels up. got=%v, %t", val, ok)
+	}
+	if !env.Assign("a", &Integer{Value: 2}) {
+		t.Errorf("cannot assign to a two levels up")
+	}
+	if val, _ := global.Get("a"); val.Inspect() != "2" {
+		t.Errorf("assignment did not update the global. got=%s", val.Inspect())
+	}
+
+	slot := global.Declare("b")
+	if _, ok := env.Get("b"); ok {
+		t.Errorf("declared variable without a value found")
+	}
+	if env.Assign("b", &Integer{Value: 3}) {
+		t.Errorf("assigned to a variable without a value")
+	}
+	env.Store(2, slot, &Integer{Value: 4})
+	if val, ok := global.Get("b"); !ok || val.Inspect() != "4" {
+		t.Errorf("stored value not found by name. got=%v, %t", val, ok)
+	}
+	if val := env.Load(2, slot); val == nil || val.Inspect() != "4" {
+		t.Errorf("wrong loaded value. got=%v", val)
+	}
+
+	fn := NewFunctionEnvironment(global, 2)
+	fn.Store(0, 1, &Integer{Value: 5})
+	if fn.Load(0, 0) != nil || fn.Load(0, 1).Inspect() != "5" || fn.Load(1, slot).Inspect() != "4" {
+		t.Errorf("wrong slots of the function environment")
+	}
+
+	global.Declare("c")
+	inner := NewEncloedEnvironment(global)
+	inner.Set("d", &Integer{Value: 6})
+	inner.Set("a", &Integer{Value: 7})
+	if names := strings.Join(inner.Names(), " "); names != "a b d" {
+		t.Errorf("wrong names. want=%q, got=%q", "a b d", names)
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	h := &Hash{}
+	for _, key := range []string{"c", "a", "d", "b"} {
+		h.Set(&String{Value: key}, &String{Value: key + key})
+	}
+	h.Set(&String{Value: "a"}, &Integer{Value: 1})
+	if value, ok := h.Delete(&String{Value: "d"}); !ok || value.Inspect() != "dd" {
+		t.Errorf("wrong deleted value. got=%v, %t", value, ok)
+	}
+	if _, ok := h.Delete(&String{Value: "d"}); ok {
+		t.Errorf("deleted a missing key")
+	}
+	h.Set(&String{Value: "d"}, &Integer{Value: 2})
+
+	if got := h.Inspect(); got != "{c: cc, a: 1, b: bb, d: 2}" {
+		t.Errorf("wrong order. got=%s", got)
+	}
+	if value, ok := h.Get(&String{Value: "b"}); !ok || value.Inspect() != "bb" {
+		t.Errorf("wrong value after deletion. got=%v, %t", value, ok)
+	}
+	if h.Len() != 4 {
+		t.Errorf("wrong length. want=4, got=%d", h.Len())
+	}
+}
+
+func TestEqual(t *testing.T) {
+	fn := &Builtin{Fn: func(args ...Object) Object { return nil }}
+	hash := func(pairs ...Object) *Hash {
+		h := &Hash{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i].(Hashable), pairs[i+1])
+		}
+		return h
+	}
+	cyclic1 := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	cyclic1.Elements[1] = cyclic1
+	cyclic2 := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	cyclic2.Elements[1] = cyclic2
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Float{Value: 1.5}, &Float{Value: 1.5}, true},
+		{&Float{Value: 1.5}, &Integer{Value: 1}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&String{Value: "a"}, &String{Value: "b"}, false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{&Array{}, &Array{}, true},
+		{&Array{Elements: []Object{&String{Value: "a"}}}, &Array{Elements: []Object{&String{Value: "a"}}}, true},
+		{&Array{Elements: []Object{&String{Value: "a"}}}, &Array{Elements: []Object{&String{Value: "b"}}}, false},
+		{hash(&String{Value: "a"}, &Integer{Value: 1}), hash(&String{Value: "a"}, &Integer{Value: 1}), true},
+		{hash(&String{Value: "a"}, &Integer{Value: 1}), hash(&String{Value: "a"}, &Integer{Value: 2}), false},
+		{hash(&Integer{Value: 1}, &Null{}), hash(&Float{Value: 1}, &Null{}), true},
+		{cyclic1, cyclic2, true},
+		{fn, fn, true},
+		{fn, &Builtin{Fn: fn.Fn}, false},
+		{&String{Value: "1"}, &Integer{Value: 1}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d] Equal wrong. want=%t, got=%t", i, tt.expected, got)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+type point struct {
+	X       int
+	Y       int    `tinyscript:"y"`
+	Label   string `tinyscript:"label,omitempty"`
+	Skipped bool   `tinyscript:"-"`
+	private int
+}
+
+func TestFromGo(t *testing.T) {
+	n := 7
+	var nilPtr *int
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint8(200), "200"},
+		{2.5, "2.5"},
+		{float32(1), "1.0"},
+		{"héllo", "héllo"},
+		{true, "true"},
+		{&n, "7"},
+		{nilPtr, "null"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]any{1, "x", nil, []bool{false}}, "[1, x, null, [false]]"},
+		{map[string]int{"b": 2}, "{b: 2}"},
+		{point{X: 1, Y: 2, Label: "p", Skipped: true}, ""},
+		{&Integer{Value: 3}, "3"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) failed: %s", tt.input, err)
+			continue
+		}
+		if tt.expected != "" && obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%#v) wrong. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	obj, _ := FromGo(point{X: 1, Y: 2, Label: "p", Skipped: true})
+	hash := obj.(*Hash)
+	for key, want := range map[string]string{"X": "1", "y": "2", "label": "p"} {
+		value, ok := hash.Get(&String{Value: key})
+		if !ok || value.Inspect() != want {
+			t.Errorf("struct field %s wrong. want=%s, got=%v", key, want, value)
+		}
+	}
+	if hash.Len() != 3 {
+		t.Errorf("struct has wrong number of fields. want=3, got=%d", hash.Len())
+	}
+
+	if _, err := FromGo(make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel")
+	}
+	if _, err := FromGo(uint64(math.MaxUint64)); err == nil {
+		t.Errorf("expected an error converting an overflowing uint64")
+	}
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestFromGoCycle(t *testing.T) {
+	loop := &node{Value: 1}
+	loop.Next = &node{Value: 2, Next: loop}
+	self := []any{1, nil}
+	self[1] = self
+	m := map[string]any{}
+	m["m"] = m
+
+	for _, v := range []any{loop, self, m} {
+		if _, err := FromGo(v); err == nil || !strings.HasPrefix(err.Error(), "cannot convert cyclic Go value") {
+			t.Errorf("wrong error converting a cyclic %T. got=%v", v, err)
+		}
+	}
+
+	shared := &node{Value: 3}
+	obj, err := FromGo([]*node{shared, shared})
+	if err != nil {
+		t.Fatalf("FromGo of a shared pointer failed: %s", err)
+	}
+	if got := obj.Inspect(); got != "[{Value: 3, Next: null}, {Value: 3, Next: null}]" {
+		t.Errorf("wrong conversion of a shared pointer. got=%s", got)
+	}
+}
+
+func TestToGoCycle(t *testing.T) {
+	arr := &Array{Elements: []Object{&Integer{Value: 1}}}
+	arr.Elements = append(arr.Elements, arr)
+	hash := &Hash{}
+	hash.Set(&String{Value: "Next"}, hash)
+
+	tests := []struct {
+		obj    Object
+		target any
+	}{
+		{arr, new(any)},
+		{arr, new([]any)},
+		{&Array{Elements: []Object{arr}}, new([][]any)},
+		{hash, new(any)},
+		{hash, new(map[string]any)},
+		{hash, new(node)},
+	}
+
+	for _, tt := range tests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || !strings.HasSuffix(err.Error(), "holding itself") {
+			t.Errorf("wrong error converting %s to %T. got=%v", tt.obj.Type(), tt.target, err)
+		}
+	}
+
+	shared := &Array{Elements: []Object{&Integer{Value: 1}}}
+	var got any
+	if err := ToGo(&Array{Elements: []Object{shared, shared}}, &got); err != nil {
+		t.Fatalf("ToGo of a shared array failed: %s", err)
+	}
+	if want := []any{[]any{int64(1)}, []any{int64(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong conversion of a shared array. want=%v, got=%v", want, got)
+	}
+}
+
+func TestFromGoFunc(t *testing.T) {
+	tests := []struct {
+		fn       any
+		args     []Object
+		expected string
+	}{
+		{func(a, b int) int { return a + b }, []Object{&Integer{Value: 1}, &Integer{Value: 2}}, "3"},
+		{func(f float64) float64 { return f / 2 }, []Object{&Integer{Value: 3}}, "1.5"},
+		{strings.ToUpper, []Object{&String{Value: "abc"}}, "ABC"},
+		{func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+			[]Object{&String{Value: "-"}, &String{Value: "a"}, &String{Value: "b"}}, "a-b"},
+		{func(p point) string { return fmt.Sprint(p.X, p.Y) },
+			[]Object{mustFromGo(t, map[string]int{"X": 3, "y": 4})}, "3 4"},
+		{func() (int, string) { return 1, "a" }, nil, "[1, a]"},
+		{func() {}, nil, "<nil>"},
+		{func(n int) (int, error) { return n, nil }, []Object{&Integer{Value: 5}}, "5"},
+		{func(n int) (int, error) { return 0, fmt.Errorf("bad %d", n) }, []Object{&Integer{Value: 5}}, "ERROR: bad 5"},
+		{func(a int) int { return a }, []Object{}, "ERROR: wrong number of arguments. got=0, want=1"},
+		{func(a int) int { return a }, []Object{&String{Value: "x"}}, "ERROR: argument 1: cannot convert STRING to int"},
+		{func(a int8) int8 { return a }, []Object{&Integer{Value: 300}}, "ERROR: argument 1: 300 overflows int8"},
+		{func(args ...Object) Object { return args[0] }, []Object{&Boolean{Value: true}}, "true"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.fn)
+		if err != nil {
+			t.Fatalf("FromGo(%T) failed: %s", tt.fn, err)
+		}
+		builtin, ok := obj.(*Builtin)
+		if !ok {
+			t.Fatalf("FromGo(%T) is not a Builtin. got=%T", tt.fn, obj)
+		}
+		result := builtin.Call(nil, nil, tt.args...)
+		got := "<nil>"
+		if result != nil {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("calling %T wrong. want=%q, got=%q", tt.fn, tt.expected, got)
+		}
+	}
+}
+
+func mustFromGo(t *testing.T, v any) Object {
+	t.Helper()
+	obj, err := FromGo(v)
+	if err != nil {
+		t.Fatalf("FromGo(%#v) failed: %s", v, err)
+	}
+	return obj
+}
+
+func TestToGo(t *testing.T) {
+	var i int
+	if err := ToGo(&Integer{Value: 5}, &i); err != nil || i != 5 {
+		t.Errorf("ToGo int wrong. got=%d (%v)", i, err)
+	}
+
+	var f float64
+	if err := ToGo(&Integer{Value: 2}, &f); err != nil || f != 2 {
+		t.Errorf("ToGo float64 wrong. got=%v (%v)", f, err)
+	}
+
+	var strs []string
+	if err := ToGo(mustFromGo(t, []string{"a", "b"}), &strs); err != nil || fmt.Sprint(strs) != "[a b]" {
+		t.Errorf("ToGo []string wrong. got=%v (%v)", strs, err)
+	}
+
+	var m map[string]int
+	if err := ToGo(mustFromGo(t, map[string]int{"a": 1, "b": 2}), &m); err != nil || m["a"] != 1 || m["b"] != 2 {
+		t.Errorf("ToGo map wrong. got=%v (%v)", m, err)
+	}
+
+	var p point
+	if err := ToGo(mustFromGo(t, point{X: 1, Y: 2, Label: "x", Skipped: true}), &p); err != nil {
+		t.Fatalf("ToGo struct failed: %s", err)
+	}
+	if p != (point{X: 1, Y: 2, Label: "x"}) {
+		t.Errorf("ToGo struct wrong. got=%+v", p)
+	}
+
+	var ptr *int
+	if err := ToGo(&Null{}, &ptr); err != nil || ptr != nil {
+		t.Errorf("ToGo null pointer wrong. got=%v (%v)", ptr, err)
+	}
+	if err := ToGo(&Integer{Value: 3}, &ptr); err != nil || ptr == nil || *ptr != 3 {
+		t.Errorf("ToGo pointer wrong. got=%v (%v)", ptr, err)
+	}
+
+	var natural any
+	if err := ToGo(mustFromGo(t, []any{1, 2.5, "s", true, nil, map[string]any{"k": []int{1}}}), &natural); err != nil {
+		t.Fatalf("ToGo any failed: %s", err)
+	}
+	if got := fmt.Sprintf("%#v", natural); got != `[]interface {}{1, 2.5, "s", true, interface {}(nil), map[string]interface {}{"k":[]interface {}{1}}}` {
+		t.Errorf("ToGo any wrong. got=%s", got)
+	}
+
+	var obj Object
+	if err := ToGo(&String{Value: "kept"}, &obj); err != nil || obj.Inspect() != "kept" {
+		t.Errorf("ToGo Object wrong. got=%v (%v)", obj, err)
+	}
+
+	// functions of the script are only callable from Go during a call
+	var callback func(int) int
+	if err := ToGo(&Function{}, &callback); err == nil || err.Error() != "cannot convert FUNCTION to func(int) int" {
+		t.Errorf("ToGo function wrong. got=%v", err)
+	}
+
+	var double func(int) (int, error)
+	if err := ToGo(mustFromGo(t, func(n int) int { return 2 * n }), &double); err != nil {
+		t.Fatalf("ToGo func failed: %s", err)
+	}
+	if n, err := double(21); err != nil || n != 42 {
+		t.Errorf("converted func wrong. got=%d (%v)", n, err)
+	}
+
+	var s string
+	if err := ToGo(&Integer{Value: 1}, &s); err == nil || err.Error() != "cannot convert INTEGER to string" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if err := ToGo(&Integer{Value: 1}, s); err == nil {
+		t.Errorf("expected an error for a non-pointer target")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	hash := &Hash{}
+	hash.Set(&String{Value: "a"}, &Integer{Value: 1})
+	tests := []struct {
+		obj      Object
+		single   bool
+		expected string // key:value pairs
+	}{
+		{&Array{Elements: []Object{&String{Value: "x"}, &String{Value: "y"}}}, false, "0:x 1:y"},
+		{&String{Value: "hé"}, true, "0:h 1:é"},
+		{hash, false, "a:1"},
+		{hash, true, "a:a"},
+	}
+
+	for _, tt := range tests {
+		keys, values, ok := Iterate(tt.obj, tt.single)
+		if !ok {
+			t.Errorf("%s not iterable", tt.obj.Inspect())
+			continue
+		}
+		var pairs []string
+		for i := range keys {
+			pairs = append(pairs, keys[i].Inspect()+":"+values[i].Inspect())
+		}
+		if got := strings.Join(pairs, " "); got != tt.expected {
+			t.Errorf("wrong pairs for %s. want=%q, got=%q", tt.obj.Inspect(), tt.expected, got)
+		}
+	}
+
+	if _, _, ok := Iterate(&Integer{Value: 1}, true); ok {
+		t.Errorf("integer is iterable")
+	}
+}
+
+func TestInspectCycle(t *testing.T) {
+	arr := &Array{Elements: []Object{&Integer{Value: 1}}}
+	arr.Elements = append(arr.Elements, arr)
+	hash := &Hash{}
+	hash.Set(&String{Value: "self"}, hash)
+	hash.Set(&String{Value: "arr"}, arr)
+	shared := &Array{}
+	twice := &Array{Elements: []Object{shared, shared}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{arr, "[1, [...]]"},
+		{hash, "{self: {...}, arr: [1, [...]]}"},
+		{twice, "[[], []]"},
+		{&Array{Elements: []Object{nil}}, "[null]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.expected {
+			t.Errorf("wrong inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	frame := func(function string, line int) Frame {
+		return Frame{Function: function, Pos: token.Position{Line: line, Column: 1}}
+	}
+	main, f, g := frame("<main>", 9), frame("f", 2), frame("g", 5)
+	repeat := func(fr Frame, n int) []Frame {
+		frames := make([]Frame, n)
+		for i := range frames {
+			frames[i] = fr
+		}
+		return frames
+	}
+
+	tests := []struct {
+		trace    []Frame
+		expected string
+	}{
+		{nil, "ERROR: oops"},
+		{[]Frame{main, f, g}, "Traceback (most recent call last):\n  9:1, in <main>\n  2:1, in f\n  5:1, in g\nERROR: oops"},
+		{append([]Frame{main}, repeat(f, 3)...),
+			"Traceback (most recent call last):\n  9:1, in <main>\n  2:1, in f\n  2:1, in f\n  2:1, in f\nERROR: oops"},
+		{append(append([]Frame{main}, repeat(f, 10000)...), g),
+			"Traceback (most recent call last):\n  9:1, in <main>\n  2:1, in f\n  2:1, in f\n  2:1, in f\n" +
+				"  ... 9997 more calls to f\n  5:1, in g\nERROR: oops"},
+		{[]Frame{main, f, g, f, g, f}, "Traceback (most recent call last):\n  9:1, in <main>\n" +
+			"  2:1, in f\n  5:1, in g\n  2:1, in f\n  5:1, in g\n  2:1, in f\nERROR: oops"},
+	}
+
+	for i, tt := range tests {
+		err := &Error{Message: "oops", Trace: tt.trace}
+		if got := err.StackTrace(); got != tt.expected {
+			t.Errorf("wrong stack trace #%d.\nwant=%q\ngot= %q", i, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// The string builtins below are registered in Builtins. Indexes, lengths
+// and widths count runes, not bytes.
+
+// builtinSplit splits a string around each instance of a separator, or
+// around runs of white space if no separator is given. An empty separator
+// splits the string into its runes.
+func builtinSplit(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	str, err := stringArgument("split", args[0])
+	if err != nil {
+		return err
+	}
+
+	var parts []string
+	if len(args) == 1 {
+		parts = strings.Fields(str.Value)
+	} else {
+		sep, err := stringArgument("split", args[1])
+		if err != nil {
+			return err
+		}
+		parts = strings.Split(str.Value, sep.Value)
+	}
+	return stringArray(parts)
+}
+
+// builtinTrim removes leading and trailing white space, or the runes of a
+// cutset if given.
+func builtinTrim(args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	str, err := stringArgument("trim", args[0])
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		return &String{Value: strings.TrimSpace(str.Value)}
+	}
+	cutset, err := stringArgument("trim", args[1])
+	if err != nil {
+		return err
+	}
+	return &String{Value: strings.Trim(str.Value, cutset.Value)}
+}
+
+func builtinUpper(args ...Object) Object {
+	return mapString("upper", strings.ToUpper, args)
+}
+
+func builtinLower(args ...Object) Object {
+	return mapString("lower", strings.ToLower, args)
+}
+
+func mapString(name string, fn func(string) string, args []Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	str, err := stringArgument(name, args[0])
+	if err != nil {
+		return err
+	}
+	return &String{Value: fn(str.Value)}
+}
+
+// builtinReplace replaces every instance of old by new, or only the first
+// n ones if n is given.
+func builtinReplace(alloc AllocFunction, args ...Object) Object {
+	if len(args) != 3 && len(args) != 4 {
+		return newError("wrong number of arguments. got=%d, want=3 or 4", len(args))
+	}
+	strs, err := stringArguments("replace", args[:3])
+	if err != nil {
+		return err
+	}
+
+	n := int64(-1)
+	if len(args) == 4 {
+		count, ok := args[3].(*Integer)
+		if !ok {
+			return newError("argument to `replace` must be INTEGER, got %s", args[3].Type())
+		}
+		n = count.Value
+	}
+
+	replaced := int64(strings.Count(strs[0], strs[1]))
+	if n >= 0 && n < replaced {
+		replaced = n
+	}
+	if err := reserve(alloc, int64(len(strs[0]))+replaced*int64(len(strs[2])-len(strs[1]))); err != nil {
+		return err
+	}
+	return &String{Value: strings.Replace(strs[0], strs[1], strs[2], int(n))}
+}
+
+func builtinContains(args ...Object) Object {
+	return testString("contains", strings.Contains, args)
+}
+
+func builtinStartsWith(args ...Object) Object {
+	return testString("starts_with", strings.HasPrefix, args)
+}
+
+func builtinEndsWith(args ...Object) Object {
+	return testString("ends_with", strings.HasSuffix, args)
+}
+
+func testString(name string, fn func(s, substr string) bool, args []Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	strs, err := stringArguments(name, args)
+	if err != nil {
+		return err
+	}
+	return &Boolean{Value: fn(strs[0], strs[1])}
+}
+
+// builtinIndex returns the index of the first instance of a substring, or
+// -1 if there is none.
+func builtinIndex(args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	strs, err := stringArguments("index", args)
+	if err != nil {
+		return err
+	}
+
+	i := strings.Index(strs[0], strs[1])
+	if i < 0 {
+		return &Integer{Value: -1}
+	}
+	return &Integer{Value: int64(utf8.RuneCountInString(strs[0][:i]))}
+}
+
+// builtinSubstr returns length runes from start on, or the rest of the
+// string if no length is given. A negative start counts from the end.
+func builtinSubstr(args ...Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+	str, err := stringArgument("substr", args[0])
+	if err != nil {
+		return err
+	}
+
+	runes := []rune(str.Value)
+	start, end, err := sliceBounds("substr", args[1:2], len(runes))
+	if err != nil {
+		return err
+	}
+	if len(args) == 3 {
+		length, ok := args[2].(*Integer)
+		if !ok {
+			return newError("argument to `substr` must be INTEGER, got %s", args[2].Type())
+		}
+		if length.Value < 0 {
+			return newError("length of `substr` must not be negative, got %d", length.Value)
+		}
+		if length.Value < int64(end-start) {
+			end = start + int(length.Value)
+		}
+	}
+	return &String{Value: string(runes[start:end])}
+}
+
+func builtinRepeat(alloc AllocFunction, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	str, err := stringArgument("repeat", args[0])
+	if err != nil {
+		return err
+	}
+	count, ok := args[1].(*Integer)
+	if !ok {
+		return newError("argument to `repeat` must be INTEGER, got %s", args[1].Type())
+	}
+
+	n := count.Value
+	if n < 0 {
+		return newError("count of `repeat` must not be negative, got %d", n)
+	}
+	if n > 0 && int64(len(str.Value))*n/n != int64(len(str.Value)) {
+		return newError("result of `repeat` too large")
+	}
+	if err := reserve(alloc, int64(len(str.Value))*n); err != nil {
+		return err
+	}
+	return &String{Value: strings.Repeat(str.Value, int(n))}
+}
+
+func builtinPadLeft(alloc AllocFunction, args ...Object) Object {
+	return pad("pad_left", true, alloc, args)
+}
+
+func builtinPadRight(alloc AllocFunction, args ...Object) Object {
+	return pad("pad_right", false, alloc, args)
+}
+
+// pad pads a string to width runes with the runes of a padding string,
+// a space by default, repeated as often as needed.
+func pad(name string, left bool, alloc AllocFunction, args []Object) Object {
+	if len(args) != 2 && len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+	str, err := stringArgument(name, args[0])
+	if err != nil {
+		return err
+	}
+	width, ok := args[1].(*Integer)
+	if !ok {
+		return newError("argument to `%s` must be INTEGER, got %s", name, args[1].Type())
+	}
+	padding := []rune(" ")
+	if len(args) == 3 {
+		p, err := stringArgument(name, args[2])
+		if err != nil {
+			return err
+		}
+		if p.Value == "" {
+			return newError("padding of `%s` must not be empty", name)
+		}
+		padding = []rune(p.Value)
+	}
+
+	n := width.Value - int64(utf8.RuneCountInString(str.Value))
+	if n <= 0 {
+		return str
+	}
+	if n > math.MaxInt64/utf8.UTFMax {
+		return newError("result of `%s` too large", name)
+	}
+	// the fill repeats the padding n/len(padding) times, then its first
+	// n%len(padding) runes
+	rounds, rest := n/int64(len(padding)), n%int64(len(padding))
+	size := rounds*int64(len(string(padding))) + int64(len(string(padding[:rest])))
+	if err := reserve(alloc, int64(len(str.Value))+size); err != nil {
+		return err
+	}
+	fill := make([]rune, n)
+	for i := range fill {
+		fill[i] = padding[i%len(padding)]
+	}
+	if left {
+		return &String{Value: string(fill) + str.Value}
+	}
+	return &String{Value: str.Value + string(fill)}
+}
+
+func builtinChars(args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+	str, err := stringArgument("chars", args[0])
+	if err != nil {
+		return err
+	}
+
+	chars := []Object{}
+	for _, r := range str.Value {
+		chars = append(chars, &String{Value: string(r)})
+	}
+	return &Array{Elements: chars}
+}
+
+// builtinFormat formats its arguments like Go's fmt.Sprintf. The verbs are
+// %d, %b, %o, %x and %c for integers, %f, %e and %g for numbers, %q for
+// strings, %t for booleans, %s and %v for any value and %% for a percent
+// sign, with Go's flags, width and precision.
+func builtinFormat(args ...Object) Object {
+	if len(args) < 1 {
+		return newError("wrong number of arguments. got=0, want=1 or more")
+	}
+	format, err := stringArgument("format", args[0])
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	s, operands := format.Value, args[1:]
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			out.WriteByte(s[i])
+			continue
+		}
+		j := i + 1
+		for j < len(s) && strings.IndexByte("+-# 0123456789.", s[j]) >= 0 {
+			j++
+		}
+		if j == len(s) {
+			return newError("format: missing verb at the end of %q", s)
+		}
+		verb, size := utf8.DecodeRuneInString(s[j:])
+		spec := s[i : j+size]
+		i = j + size - 1
+
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if len(operands) == 0 {
+			return newError("format: missing argument for %s", spec)
+		}
+		value, err := formatOperand(spec, verb, operands[0])
+		if err != nil {
+			return err
+		}
+		operands = operands[1:]
+		fmt.Fprintf(&out, spec, value)
+	}
+	if len(operands) > 0 {
+		return newError("format: %d arguments left over", len(operands))
+	}
+	return &String{Value: out.String()}
+}
+
+// formatOperand returns the Go value to format obj with the verb of spec.
+func formatOperand(spec string, verb rune, obj Object) (any, *Error) {
+	switch verb {
+	case 'd', 'b', 'o', 'x', 'X', 'c':
+		if n, ok := obj.(*Integer); ok {
+			return n.Value, nil
+		}
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		switch n := obj.(type) {
+		case *Integer:
+			return float64(n.Value), nil
+		case *Float:
+			return n.Value, nil
+		}
+	case 'q':
+		if str, ok := obj.(*String); ok {
+			return str.Value, nil
+		}
+	case 't':
+		if b, ok := obj.(*Boolean); ok {
+			return b.Value, nil
+		}
+	case 's', 'v':
+		return obj.Inspect(), nil
+	default:
+		return nil, newError("format: unknown verb %s", spec)
+	}
+	return nil, newError("format: cannot format %s with %s", obj.Type(), spec)
+}
+
+func stringArgument(name string, arg Object) (*String, *Error) {
+	str, ok := arg.(*String)
+	if !ok {
+		return nil, newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+	}
+	return str, nil
+}
+
+func stringArguments(name string, args []Object) ([]string, *Error) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, err := stringArgument(name, arg)
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &String{Value: s}
+	}
+	return &Array{Elements: elements}
+}
@@ -3,8 +3,10 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/diagnostic"
 	"github.com/startdusk/tinyscript/lexer"
 	"github.com/startdusk/tinyscript/token"
 )
@@ -12,6 +14,9 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -21,17 +26,37 @@ const (
 	INDEX
 )
 
+// Diagnostic codes reported by the parser.
+const (
+	CodeUnexpectedToken   = "unexpected-token"
+	CodeMissingExpression = "missing-expression"
+	CodeInvalidLiteral    = "invalid-literal"
+	CodeOutsideLoop       = "outside-loop"
+	CodeInvalidAssignment = "invalid-assignment"
+)
+
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type Parser struct {
@@ -40,7 +65,19 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 
-	errors []string
+	// comments collected while reading tokens, if the lexer emits them,
+	// and the comment groups directly above the current and next token.
+	comments []*ast.CommentGroup
+	curDoc   *ast.CommentGroup
+	peekDoc  *ast.CommentGroup
+
+	errors []diagnostic.Diagnostic
+	// panicking is set after a syntax error and cleared once the parser
+	// has re-synchronized, so that one mistake is reported only once.
+	panicking bool
+	// loopDepth counts the loops enclosing the current statement within
+	// the current function body.
+	loopDepth int
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -56,6 +93,7 @@ func New(l *lexer.Lexer) *Parser {
 	{
 		p.registerPrefix(token.IDENT, p.parseIdentifier)
 		p.registerPrefix(token.INT, p.parseIntegerLiteral)
+		p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 		p.registerPrefix(token.BANG, p.parsePrefixExpression)
 		p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 		p.registerPrefix(token.TRUE, p.parseBoolean)
@@ -65,6 +103,7 @@ func New(l *lexer.Lexer) *Parser {
 		p.registerPrefix(token.ELSE, p.parseIfExpression)
 		p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 		p.registerPrefix(token.STRING, p.parseStringLiteral)
+		p.registerPrefix(token.INTERP_START, p.parseInterpolatedString)
 		p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 		p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	}
@@ -79,26 +118,123 @@ func New(l *lexer.Lexer) *Parser {
 		p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 		p.registerInfix(token.LT, p.parseInfixExpression)
 		p.registerInfix(token.GT, p.parseInfixExpression)
+		p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+		p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+		p.registerInfix(token.PERCENT, p.parseInfixExpression)
+		p.registerInfix(token.AND, p.parseInfixExpression)
+		p.registerInfix(token.OR, p.parseInfixExpression)
 		p.registerInfix(token.LPAREN, p.parseCallExpression)
 		p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+		p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+		p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+		p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+		p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+		p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+		p.registerInfix(token.PERCENT_ASSIGN, p.parseAssignExpression)
 	}
 
 	return &p
 }
 
+// Errors returns the syntax errors as "line:column: message" strings.
 func (p *Parser) Errors() []string {
+	var msgs []string
+	for _, d := range p.errors {
+		msgs = append(msgs, d.Error())
+	}
+	return msgs
+}
+
+// Diagnostics returns the syntax errors found while parsing.
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
 	return p.errors
 }
 
+func (p *Parser) errorAt(tok token.Token, code, msg string, hints ...string) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.errors = append(p.errors, diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Code:     code,
+		Message:  msg,
+		Hints:    hints,
+	})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %q, got %q instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	msg := fmt.Sprintf("expected %s, got %s", describeType(t), describe(p.peekToken))
+	var hints []string
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		hints = append(hints, fmt.Sprintf("is a closing %q missing?", t))
+	}
+	p.errorAt(p.peekToken, CodeUnexpectedToken, msg, hints...)
+}
+
+// synchronize skips tokens after a syntax error until the current token
+// ends a statement or the next one starts a new statement or closes the
+// enclosing block. Blocks opened while skipping are skipped as a whole.
+func (p *Parser) synchronize() {
+	p.panicking = false
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+		if depth == 0 {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.WHILE, token.FOR,
+				token.BREAK, token.CONTINUE, token.RBRACE, token.EOF:
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// failedAtCur reports whether the last syntax error was reported at the
+// current token.
+func (p *Parser) failedAtCur() bool {
+	return len(p.errors) > 0 && p.errors[len(p.errors)-1].Pos == p.curToken.Pos
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.curDoc = p.peekDoc
+	p.peekDoc = nil
+
+	var group *ast.CommentGroup
+	for {
+		p.peekToken = p.l.NextToken()
+		if p.peekToken.Type != token.COMMENT {
+			break
+		}
+		comment := &ast.Comment{Token: p.peekToken}
+		// a comment after code on the same line, or after a blank line,
+		// starts a new group
+		if group == nil || group.End().Line == p.curToken.End.Line ||
+			comment.Pos().Line > group.End().Line+1 {
+			group = &ast.CommentGroup{}
+			p.comments = append(p.comments, group)
+		}
+		group.List = append(group.List, comment)
+	}
+	if group != nil && group.End().Line+1 == p.peekToken.Pos.Line &&
+		group.Pos().Line > p.curToken.End.Line {
+		p.peekDoc = group
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -108,8 +244,12 @@ func (p *Parser) ParseProgram() *ast.Program {
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize()
+		}
 		p.nextToken()
 	}
+	program.Comments = p.comments
 
 	return program
 }
@@ -126,6 +266,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -133,6 +274,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken
 	return &hash
 }
 
@@ -144,12 +286,14 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken
 	return &exp
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
 
 	return &array
 }
@@ -180,12 +324,45 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken}
+	for {
+		if p.curToken.Literal != "" {
+			str.Parts = append(str.Parts, &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if p.curTokenIs(token.INTERP_END) {
+			str.Close = p.curToken
+			return str
+		}
+
+		p.nextToken()
+		expr := p.parseExpression(LOWEST)
+		if expr == nil {
+			return nil
+		}
+		str.Parts = append(str.Parts, expr)
+
+		if !p.peekTokenIs(token.INTERP_MIDDLE) && !p.expectPeek(token.INTERP_END) {
+			return nil
+		}
+		if p.peekTokenIs(token.INTERP_MIDDLE) {
+			p.nextToken()
+		}
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -207,9 +384,91 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	return &stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := ast.WhileStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return &stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return &stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+	if p.loopDepth == 0 {
+		p.errorAt(tok, CodeOutsideLoop, fmt.Sprintf("%q outside of a loop", tok.Literal))
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := ast.LetStatement{
 		Token: p.curToken,
+		Doc:   p.curDoc,
 	}
 
 	if !p.expectPeek(token.IDENT) {
@@ -224,6 +483,9 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -278,7 +540,23 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, CodeInvalidLiteral, msg)
+		return nil
+	}
+	lit.Value = value
+
+	return &lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := ast.FloatLiteral{
+		Token: p.curToken,
+	}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.errorAt(p.curToken, CodeInvalidLiteral, msg)
 		return nil
 	}
 	lit.Value = value
@@ -312,6 +590,30 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return &expression
 }
 
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil
+	default:
+		msg := fmt.Sprintf("cannot assign to %s", target.String())
+		p.errorAt(p.curToken, CodeInvalidAssignment, msg,
+			"only variables and index expressions can be assigned to")
+		return nil
+	}
+
+	// assignments are right associative: a = b = c is a = (b = c)
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+	return &expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -359,8 +661,17 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.panicking {
+			if p.curTokenIs(token.RBRACE) && p.failedAtCur() {
+				// the closing brace was taken while looking for an expression
+				p.panicking = false
+				break
+			}
+			p.synchronize()
+		}
 		p.nextToken()
 	}
+	block.Rbrace = p.curToken
 
 	return &block
 }
@@ -380,7 +691,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// break and continue never reach a loop outside the function
+	depth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = depth
 	return lit
 }
 
@@ -425,6 +740,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 		Function: function,
 	}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return &exp
 }
 
@@ -455,8 +771,66 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 // ============================================================================================================
 // helper function
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	var hints []string
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		hints = append(hints, fmt.Sprintf("is there an unmatched %q or a missing operand?", t))
+	case token.ILLEGAL:
+		p.errorAt(p.curToken, CodeUnexpectedToken, describeIllegal(p.curToken.Literal))
+		return
+	}
+	msg := fmt.Sprintf("expected an expression, got %s", describe(p.curToken))
+	p.errorAt(p.curToken, CodeMissingExpression, msg, hints...)
+}
+
+// describeIllegal explains an ILLEGAL token by the kind of text the lexer
+// gave up on.
+func describeIllegal(lit string) string {
+	switch {
+	case strings.HasPrefix(lit, "/*"):
+		return "unterminated block comment"
+	case strings.HasPrefix(lit, "\"") || strings.HasPrefix(lit, "`"):
+		return "unterminated string literal"
+	case strings.HasPrefix(lit, "\\") && len(lit) > 1:
+		return fmt.Sprintf("invalid escape sequence %s", lit)
+	default:
+		return fmt.Sprintf("illegal character %q", lit)
+	}
+}
+
+// describe returns a human readable description of tok for error messages.
+func describe(tok token.Token) string {
+	switch tok.Type {
+	case token.EOF:
+		return "end of input"
+	case token.INTERP_MIDDLE, token.INTERP_END:
+		return `"}"`
+	}
+	return fmt.Sprintf("%q", tok.Literal)
+}
+
+// describeType returns a human readable description of t for error messages.
+func describeType(t token.TokenType) string {
+	switch t {
+	case token.IDENT:
+		return "identifier"
+	case token.INT:
+		return "integer"
+	case token.FLOAT:
+		return "float"
+	case token.STRING:
+		return "string"
+	case token.INTERP_MIDDLE, token.INTERP_END:
+		return `"}"`
+	case token.EOF:
+		return "end of input"
+	}
+	for keyword, typ := range token.Keywords {
+		if typ == t {
+			return fmt.Sprintf("%q", keyword)
+		}
+	}
+	return fmt.Sprintf("%q", string(t))
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -485,6 +859,12 @@ func (p *Parser) curPrecedence() int {
 	return getPredence(p.curToken.Type)
 }
 
+// Precedence returns how tightly the infix operator typ binds, LOWEST for
+// tokens that are not infix operators.
+func Precedence(typ token.TokenType) int {
+	return getPredence(typ)
+}
+
 func getPredence(typ token.TokenType) int {
 	if p, ok := precedences[typ]; ok {
 		return p
@@ -38,7 +38,7 @@ let = 10;
 let 838383;
 `,
 			wantErr: true,
-			errLen:  4,
+			errLen:  3,
 		},
 	}
 
@@ -225,6 +225,40 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E3;", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -345,6 +379,34 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"x >= 0 && x < n",
+			"((x >= 0) && (x < n))",
+		},
+		{
+			"a + b % c <= d",
+			"((a + (b % c)) <= d)",
+		},
+		{
+			"ok = a || b",
+			"(ok = (a || b))",
+		},
+		{
+			"a = b = c + 1",
+			"(a = (b = (c + 1)))",
+		},
+		{
+			"a[i] += b * 2",
+			"((a[i]) += (b * 2))",
+		},
+		{
+			"x %= f(y = 1)",
+			"(x %= f((y = 1)))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -770,6 +832,38 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedParts int
+		expected      string
+	}{
+		{`"hello ${name}!"`, 3, "hello ${name}!"},
+		{`"${a}${b + 1}"`, 2, "${a}${(b + 1)}"},
+		{`"n: ${len(xs)} of ${"${x}s"}"`, 4, "n: ${len(xs)} of ${${x}s}"},
+		{`"${ {"}": 1}["}"] }"`, 1, "${({}:1}[}])}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Errorf("wrong number of parts for %q. want=%d, got=%d", tt.input, tt.expectedParts, len(str.Parts))
+		}
+		if str.String() != tt.expected {
+			t.Errorf("wrong string. want=%q, got=%q", tt.expected, str.String())
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := `[1, 2 * 2, 3 + 3]`
 
@@ -909,3 +1003,239 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		testFunc(value)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b;
+};
+add(1, [2, 3][0]);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	infix := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1]
+
+	tests := []struct {
+		node         ast.Node
+		expectedPos  string
+		expectedEnd  string
+		expectedSpan string
+	}{
+		{let, "1:1", "3:2", "let add = fn(a, b) {\n  a + b;\n}"},
+		{fn, "1:11", "3:2", "fn(a, b) {\n  a + b;\n}"},
+		{fn.Body, "1:20", "3:2", "{\n  a + b;\n}"},
+		{infix, "2:3", "2:8", "a + b"},
+		{call, "4:1", "4:18", "add(1, [2, 3][0])"},
+		{index, "4:8", "4:17", "[2, 3][0]"},
+		{program, "1:1", "4:18", input[:len(input)-1]},
+	}
+
+	for _, tt := range tests {
+		pos, end := tt.node.Pos(), tt.node.End()
+		if pos.String() != tt.expectedPos {
+			t.Errorf("%T.Pos() wrong. expected=%q, got=%q", tt.node, tt.expectedPos, pos)
+		}
+		if end.String() != tt.expectedEnd {
+			t.Errorf("%T.End() wrong. expected=%q, got=%q", tt.node, tt.expectedEnd, end)
+		}
+		if span := input[pos.Offset:end.Offset]; span != tt.expectedSpan {
+			t.Errorf("%T span wrong. expected=%q, got=%q", tt.node, tt.expectedSpan, span)
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x; }", "while (x < 10) x"},
+		{"while (true) { if (true) { break; } continue; }", "while true if true break;continue;"},
+		{"for (x in [1, 2]) { x }", "for (x in [1, 2]) x"},
+		{"for (k, v in h) { puts(k, v); }", "for (k, v in h) puts(k, v)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("for (k, v in h) { v }")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("statement is not *ast.ForStatement. got=%T", program.Statements[0])
+	}
+	if !testIdentifier(t, stmt.Key, "k") || !testIdentifier(t, stmt.Value, "v") {
+		return
+	}
+	if !testIdentifier(t, stmt.Iterable, "h") {
+		return
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Errorf("body does not contain 1 statement. got=%d", len(stmt.Body.Statements))
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// Package comment.
+
+// add returns the sum
+// of a and b.
+let add = fn(a, b) { a + b }; // trailing
+
+let x = 1;
+/* not a doc comment */
+
+let y = add(x, 2);
+/* doc */ let z = 3;`
+
+	l := lexer.New(input, lexer.WithComments())
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("program.Statements does not contain 4 statements. got=%d", len(program.Statements))
+	}
+	expectedDocs := []string{"add returns the sum\nof a and b.", "", "", ""}
+	for i, expected := range expectedDocs {
+		stmt := program.Statements[i].(*ast.LetStatement)
+		doc := ""
+		if stmt.Doc != nil {
+			doc = stmt.Doc.Text()
+		}
+		if doc != expected {
+			t.Errorf("statement %d: wrong doc. want=%q, got=%q", i, expected, doc)
+		}
+	}
+
+	expectedGroups := []string{
+		"Package comment.",
+		"add returns the sum\nof a and b.",
+		"trailing",
+		"not a doc comment",
+		"doc",
+	}
+	if len(program.Comments) != len(expectedGroups) {
+		t.Fatalf("wrong number of comment groups. want=%d, got=%d", len(expectedGroups), len(program.Comments))
+	}
+	for i, expected := range expectedGroups {
+		if got := program.Comments[i].Text(); got != expected {
+			t.Errorf("comment group %d: want=%q, got=%q", i, expected, got)
+		}
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let x 5; let y = 10; y;",
+			[]string{`1:7: expected "=", got "5"`},
+		},
+		{
+			"let = 10;\nlet y = 10 +;\nlet z = 3;",
+			[]string{
+				`1:5: expected identifier, got "="`,
+				`2:13: expected an expression, got ";"`,
+			},
+		},
+		{
+			"let f = fn(x) {\n  let y = x +\n};\nf(1);",
+			[]string{`3:1: expected an expression, got "}"`},
+		},
+		{
+			"let a = [1, 2;\nlet b = add(1, 2;\nlet c = 3",
+			[]string{
+				`1:14: expected "]", got ";"`,
+				`2:17: expected ")", got ";"`,
+			},
+		},
+		{
+			"if (x > 1 { x }; let y = 1;",
+			[]string{`1:11: expected ")", got "{"`},
+		},
+		{
+			"let x = 1 $ 2;",
+			[]string{`1:11: illegal character "$"`},
+		},
+		{
+			"break; while (true) { let f = fn() { continue; }; break; }",
+			[]string{
+				`1:1: "break" outside of a loop`,
+				`1:38: "continue" outside of a loop`,
+			},
+		},
+		{
+			"let x = 1; /* never closed",
+			[]string{`1:12: unterminated block comment`},
+		},
+		{
+			"let s = \"never closed;\nlet t = `raw\nlet u = 1;",
+			[]string{
+				`1:9: unterminated string literal`,
+				`2:9: unterminated string literal`,
+			},
+		},
+		{
+			"let s = \"caf\\é\";",
+			[]string{`1:13: invalid escape sequence \é`},
+		},
+		{
+			"let s = \"a ${} b\"; let t = \"${x y}\";",
+			[]string{
+				`1:14: expected an expression, got "}"`,
+				`1:33: expected "}", got "y"`,
+			},
+		},
+		{
+			"1 = x; f() += 2;",
+			[]string{
+				`1:3: cannot assign to 1`,
+				`1:12: cannot assign to f()`,
+			},
+		},
+		{
+			"for (x y) { x }; let y = 1;",
+			[]string{`1:8: expected "in", got "y"`},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) != len(tt.expected) {
+			t.Errorf("input %q: wrong number of errors. want=%d, got=%d (%q)",
+				tt.input, len(tt.expected), len(errs), errs)
+			continue
+		}
+		for i, msg := range tt.expected {
+			if errs[i] != msg {
+				t.Errorf("input %q: wrong error. want=%q, got=%q", tt.input, msg, errs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/token"
+)
+
+// command is a REPL command, entered as a colon followed by its name and
+// argument.
+type command struct {
+	name string
+	arg  string // name of the argument in the help, if any
+	help string
+	run  func(s *session, arg string) bool // returns false to end the session
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"help", "", "list the commands", (*session).help},
+		{"load", "file", "evaluate a script file", (*session).load},
+		{"save", "file", "save the inputs evaluated so far to a file", (*session).save},
+		{"env", "", "list the variables with their types", (*session).listEnv},
+		{"type", "expr", "show the type of the value of expr", (*session).typeOf},
+		{"ast", "code", "show the syntax tree of code", (*session).ast},
+		{"tokens", "code", "show the tokens of code", (*session).tokens},
+		{"time", "code", "evaluate code and show how long it took", (*session).time},
+		{"reset", "", "forget all variables and inputs", (*session).resetCommand},
+		{"quit", "", "end the session", (*session).quit},
+	}
+}
+
+// isCommand reports whether input is a command rather than code.
+func isCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), ":")
+}
+
+// command runs the command in input. It returns false if the session
+// should end.
+func (s *session) command(input string) bool {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(input), ":"), " ")
+	arg = strings.TrimSpace(arg)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.arg != "" && arg == "" {
+			fmt.Fprintf(s.out, "usage: :%s <%s>\n", cmd.name, cmd.arg)
+			return true
+		}
+		return cmd.run(s, arg)
+	}
+	fmt.Fprintf(s.out, "unknown command :%s, see :help\n", name)
+	return true
+}
+
+func (s *session) help(string) bool {
+	for _, cmd := range commands {
+		usage := ":" + cmd.name
+		if cmd.arg != "" {
+			usage += " <" + cmd.arg + ">"
+		}
+		fmt.Fprintf(s.out, "%-16s %s\n", usage, cmd.help)
+	}
+	return true
+}
+
+func (s *session) load(file string) bool {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(s.out, "cannot load %s: %s\n", file, err)
+		return true
+	}
+	return s.run(string(src))
+}
+
+func (s *session) save(file string) bool {
+	var out strings.Builder
+	for _, input := range s.inputs {
+		out.WriteString(input)
+		out.WriteString("\n")
+	}
+	if err := os.WriteFile(file, []byte(out.String()), 0o644); err != nil {
+		fmt.Fprintf(s.out, "cannot save %s: %s\n", file, err)
+		return true
+	}
+	fmt.Fprintf(s.out, "saved %d inputs to %s\n", len(s.inputs), file)
+	return true
+}
+
+func (s *session) listEnv(string) bool {
+	names, values := s.bindings()
+	for i, name := range names {
+		fmt.Fprintf(s.out, "%s: %s = %s\n", name, values[i].Type(), values[i].Inspect())
+	}
+	return true
+}
+
+func (s *session) typeOf(expr string) bool {
+	result, exited := s.eval(expr)
+	if result != nil {
+		fmt.Fprintln(s.out, result.Type())
+	}
+	return !exited
+}
+
+func (s *session) ast(code string) bool {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(code, p.Diagnostics())
+		return true
+	}
+	dumpAST(s.out, "", program, 0)
+	return true
+}
+
+func (s *session) tokens(code string) bool {
+	l := lexer.New(code, lexer.WithComments())
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%-8s %-14s %q\n", tok.Pos, tok.Type, tok.Literal)
+	}
+	return true
+}
+
+func (s *session) time(code string) bool {
+	start := time.Now()
+	result, exited := s.eval(code)
+	elapsed := time.Since(start)
+	if result != nil {
+		io.WriteString(s.out, result.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+	fmt.Fprintf(s.out, "took %s\n", elapsed.Round(time.Microsecond))
+	return !exited
+}
+
+func (s *session) resetCommand(string) bool {
+	s.reset()
+	return true
+}
+
+func (s *session) quit(string) bool {
+	return false
+}
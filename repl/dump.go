@@ -0,0 +1,80 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/startdusk/tinyscript/ast"
+)
+
+// dumpAST writes the syntax tree of node to w, one node per line with its
+// position, indented by depth. label names the field of the parent node
+// holding node.
+func dumpAST(w io.Writer, label string, node ast.Node, depth int) {
+	if label != "" {
+		label += ": "
+	}
+	name := strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+	fmt.Fprintf(w, "%s%s%s%s %s\n", strings.Repeat("  ", depth), label, name, nodeDetail(node), node.Pos())
+
+	if hash, ok := node.(*ast.HashLiteral); ok {
+		for _, key := range hash.Keys {
+			dumpAST(w, "Key", key, depth+1)
+			dumpAST(w, "Value", hash.Pairs[key], depth+1)
+		}
+		return
+	}
+
+	v := reflect.ValueOf(node).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field, name := v.Field(i), v.Type().Field(i).Name
+		if field.Kind() == reflect.Slice {
+			for j := 0; j < field.Len(); j++ {
+				if child, ok := childNode(field.Index(j)); ok {
+					dumpAST(w, fmt.Sprintf("%s[%d]", name, j), child, depth+1)
+				}
+			}
+		} else if child, ok := childNode(field); ok {
+			dumpAST(w, name, child, depth+1)
+		}
+	}
+}
+
+// childNode returns the node held by v, if it holds a non-nil one.
+func childNode(v reflect.Value) (ast.Node, bool) {
+	if v.Kind() != reflect.Interface && v.Kind() != reflect.Pointer {
+		return nil, false
+	}
+	if v.IsNil() || (v.Kind() == reflect.Interface && v.Elem().Kind() == reflect.Pointer && v.Elem().IsNil()) {
+		return nil, false
+	}
+	node, ok := v.Interface().(ast.Node)
+	return node, ok
+}
+
+// nodeDetail returns what sets node apart from other nodes of its type,
+// like the name of an identifier or the operator of an expression.
+func nodeDetail(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return " " + node.Value
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.Boolean:
+		return " " + node.String()
+	case *ast.StringLiteral:
+		return " " + strconv.Quote(node.Value)
+	case *ast.PrefixExpression:
+		return " " + node.Operator
+	case *ast.InfixExpression:
+		return " " + node.Operator
+	case *ast.AssignExpression:
+		return " " + node.Operator
+	case *ast.FunctionLiteral:
+		if node.Name != "" {
+			return " " + node.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,312 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// errInterrupted is returned by readLine when the user presses Ctrl-C to
+// discard the input.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the input of the REPL line by line.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// newLineReader returns an editor if in is a terminal, and a reader of
+// plain lines otherwise.
+func newLineReader(in io.Reader, out io.Writer, hist *history, names func() []string) lineReader {
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		return &editor{
+			fd:      int(f.Fd()),
+			in:      bufio.NewReader(f),
+			out:     out,
+			history: hist,
+			names:   names,
+		}
+	}
+	return &plainReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+type plainReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *plainReader) readLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// editor reads lines from a terminal in raw mode, with Emacs-style line
+// editing, a history browsed with the arrow keys and tab completion of the
+// names returned by names.
+type editor struct {
+	fd      int
+	in      *bufio.Reader
+	out     io.Writer
+	history *history
+	names   func() []string
+
+	prompt  string
+	buf     []rune
+	pos     int
+	index   int    // position in the history, len(history.lines) for the new line
+	current []rune // the new line, while browsing the history
+}
+
+// Keys with a special meaning to the editor.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+func (e *editor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	return e.edit(prompt)
+}
+
+// edit reads a line key by key, redrawing it after each one.
+func (e *editor) edit(prompt string) (string, error) {
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	e.index, e.current = len(e.history.lines), nil
+	e.refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case keyEnter, '\n':
+			io.WriteString(e.out, "\n")
+			line := string(e.buf)
+			e.history.add(line)
+			return line, nil
+		case keyCtrlC:
+			io.WriteString(e.out, "^C\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				io.WriteString(e.out, "\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.buf)
+		case keyCtrlB:
+			e.move(-1)
+		case keyCtrlF:
+			e.move(1)
+		case keyCtrlP:
+			e.browse(-1)
+		case keyCtrlN:
+			e.browse(1)
+		case keyBackspace, keyCtrlH:
+			e.delete(e.pos-1, e.pos)
+		case keyCtrlK:
+			e.delete(e.pos, len(e.buf))
+		case keyCtrlU:
+			e.delete(0, e.pos)
+		case keyCtrlW:
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			e.delete(start, e.pos)
+		case keyCtrlL:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case keyTab:
+			e.complete()
+		case keyEscape:
+			e.escape()
+		default:
+			if unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+		e.refresh()
+	}
+}
+
+// escape handles the escape sequences sent by the arrow, home, end and
+// delete keys.
+func (e *editor) escape() {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	var seq strings.Builder
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return
+		}
+		seq.WriteRune(r)
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+	}
+	switch seq.String() {
+	case "A":
+		e.browse(-1)
+	case "B":
+		e.browse(1)
+	case "C":
+		e.move(1)
+	case "D":
+		e.move(-1)
+	case "H", "1~", "7~":
+		e.pos = 0
+	case "F", "4~", "8~":
+		e.pos = len(e.buf)
+	case "3~":
+		e.delete(e.pos, e.pos+1)
+	}
+}
+
+func (e *editor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+// delete removes the runes from start up to end, as far as they exist.
+func (e *editor) delete(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(e.buf) {
+		end = len(e.buf)
+	}
+	if start >= end {
+		return
+	}
+	e.buf = append(e.buf[:start], e.buf[end:]...)
+	e.pos = start
+}
+
+func (e *editor) move(delta int) {
+	if pos := e.pos + delta; pos >= 0 && pos <= len(e.buf) {
+		e.pos = pos
+	}
+}
+
+// browse replaces the line with an older (delta -1) or a newer (delta 1)
+// line of the history.
+func (e *editor) browse(delta int) {
+	index := e.index + delta
+	if index < 0 || index > len(e.history.lines) {
+		return
+	}
+	if e.index == len(e.history.lines) {
+		e.current = e.buf
+	}
+	e.index = index
+	if index == len(e.history.lines) {
+		e.buf = e.current
+	} else {
+		e.buf = []rune(e.history.lines[index])
+	}
+	e.pos = len(e.buf)
+}
+
+func (e *editor) complete() {
+	buf, pos, matches := complete(e.buf, e.pos, e.names())
+	if pos != e.pos {
+		e.buf, e.pos = buf, pos
+		return
+	}
+	if len(matches) > 1 {
+		fmt.Fprintf(e.out, "\n%s\n", strings.Join(matches, "  "))
+	}
+}
+
+// refresh redraws the line and puts the cursor at its position.
+func (e *editor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(e.buf))
+	if n := len(e.buf) - e.pos; n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
+
+// complete completes the identifier before pos in line to the longest
+// prefix shared by the names starting with it. It returns the new line
+// and cursor position, and the matching names.
+func complete(line []rune, pos int, names []string) ([]rune, int, []string) {
+	start := pos
+	for start > 0 && isIdentRune(line[start-1]) {
+		start--
+	}
+	prefix := string(line[start:pos])
+	if prefix == "" {
+		return line, pos, nil
+	}
+
+	seen := make(map[string]bool)
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return line, pos, nil
+	}
+	sort.Strings(matches)
+
+	common := []rune(matches[0])
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, string(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+	rest := common[len([]rune(prefix)):]
+	completed := make([]rune, 0, len(line)+len(rest))
+	completed = append(completed, line[:pos]...)
+	completed = append(completed, rest...)
+	completed = append(completed, line[pos:]...)
+	return completed, pos + len(rest), matches
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,64 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// MaxHistory is the number of lines kept in the history.
+const MaxHistory = 1000
+
+// history holds the lines entered so far, oldest first, and saves every
+// new line to a file, if it has one.
+type history struct {
+	lines []string
+	file  string
+}
+
+// loadHistory reads the history saved in file, if it exists. A file longer
+// than MaxHistory lines is cut to the most recent ones.
+func loadHistory(file string) *history {
+	h := &history{file: file}
+	if file == "" {
+		return h
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > MaxHistory {
+		h.lines = h.lines[len(h.lines)-MaxHistory:]
+		os.WriteFile(file, []byte(strings.Join(h.lines, "\n")+"\n"), 0o600)
+	}
+	return h
+}
+
+// add appends line to the history, unless it is blank or repeats the last
+// line. Failing to save it is not an error worth interrupting the user for.
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > MaxHistory {
+		h.lines = h.lines[1:]
+	}
+	if h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
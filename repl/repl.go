@@ -1,17 +1,28 @@
 package repl
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"os/user"
+	"sort"
+	"strings"
 
+	"github.com/startdusk/tinyscript/compiler"
+	"github.com/startdusk/tinyscript/diagnostic"
 	"github.com/startdusk/tinyscript/evaluator"
 	"github.com/startdusk/tinyscript/lexer"
 	"github.com/startdusk/tinyscript/object"
 	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/resolver"
+	"github.com/startdusk/tinyscript/token"
+	"github.com/startdusk/tinyscript/vm"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT              = ">> "
+	CONTINUATION_PROMPT = ".. "
+)
 
 const LOGO = ` __,__
 _____ _                           _       _   
@@ -22,38 +33,254 @@ _____ _                           _       _
                |___/                |_|        
 `
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// Engine selects how the REPL executes programs.
+type Engine string
+
+const (
+	EngineEval Engine = "eval" // tree-walking evaluator
+	EngineVM   Engine = "vm"   // bytecode compiler and virtual machine
+)
+
+// Options configure a REPL session.
+type Options struct {
+	Engine      Engine
+	HistoryFile string // file the history is kept in across sessions, none if empty
+	Quiet       bool   // leave out the banner and the logo above parser errors
+}
+
+func Start(in io.Reader, out io.Writer, engine Engine) {
+	StartWithOptions(in, out, Options{Engine: engine})
+}
+
+// StartWithOptions runs a REPL session reading from in and writing to out
+// until the input ends, the script exits or the user enters :quit. If in
+// is a terminal, lines are read with a line editor which keeps a history
+// and completes names with the tab key.
+func StartWithOptions(in io.Reader, out io.Writer, opts Options) {
+	s := newSession(out, opts)
+	if !opts.Quiet {
+		printBanner(out)
+	}
 
+	reader := newLineReader(in, out, loadHistory(opts.HistoryFile), s.completions)
 	for {
-		fmt.Print(PROMPT)
-		if scanned := scanner.Scan(); !scanned {
+		src, err := readInput(reader)
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
 			return
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if isCommand(src) {
+			if !s.command(src) {
+				return
+			}
 			continue
 		}
+		if !s.run(src) {
+			return
+		}
+	}
+}
+
+// session holds the state of a REPL session, which is shared by the
+// inputs evaluated in it.
+type session struct {
+	out  io.Writer
+	opts Options
+
+	env         *object.Environment
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+	inputs      []string // inputs evaluated without errors, for :save
+}
+
+func newSession(out io.Writer, opts Options) *session {
+	s := &session{out: out, opts: opts}
+	s.reset()
+	return s
+}
+
+// reset forgets all variables and inputs.
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	s.symbolTable = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbolTable.DefineBuiltin(i, v.Name)
+	}
+	s.inputs = nil
+}
+
+// run evaluates src and prints its result. It returns false if the script
+// exited.
+func (s *session) run(src string) bool {
+	result, exited := s.eval(src)
+	if result != nil {
+		io.WriteString(s.out, result.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+	return !exited
+}
+
+// eval evaluates src and returns its value, which is nil if src does not
+// produce one or fails. Syntax and runtime errors are printed. eval also
+// reports whether the script exited.
+func (s *session) eval(src string) (result object.Object, exited bool) {
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		s.printParserErrors(src, p.Diagnostics())
+		return nil, false
+	}
+	diags := resolver.Resolve(program, s.env, isBuiltin)
+	diagnostic.RenderAll(s.out, src, diags)
+	if diagnostic.HasErrors(diags) {
+		return nil, false
+	}
+
+	var evaluated object.Object
+	if s.opts.Engine == EngineVM {
+		comp := compiler.NewWithState(s.symbolTable, s.constants)
+		if err := comp.Compile(program); err != nil {
+			fmt.Fprintf(s.out, "Woops! Compilation failed:\n %s\n", err)
+			return nil, false
+		}
+		bytecode := comp.Bytecode()
+		s.constants = bytecode.Constants
+
+		machine := vm.NewWithGlobalsState(bytecode, s.globals)
+		if err := machine.Run(); err != nil {
+			errObj := &object.Error{Message: err.Error()}
+			errors.As(err, &errObj)
+			evaluated = errObj
+		} else {
+			evaluated = machine.LastPoppedStackElem()
+		}
+	} else {
+		evaluated = evaluator.Eval(program, s.env)
+	}
+
+	if errObj, ok := evaluated.(*object.Error); ok {
+		var exit *object.ExitError
+		if errors.As(errObj, &exit) {
+			return nil, true
+		}
+		io.WriteString(s.out, errObj.StackTrace())
+		io.WriteString(s.out, "\n")
+		return nil, false
+	}
+	s.inputs = append(s.inputs, src)
+	return evaluated, false
+}
+
+// bindings returns the variables of the session and their values, sorted
+// by name.
+func (s *session) bindings() ([]string, []object.Object) {
+	if s.opts.Engine != EngineVM {
+		names := s.env.Names()
+		values := make([]object.Object, len(names))
+		for i, name := range names {
+			values[i], _ = s.env.Get(name)
+		}
+		return names, values
+	}
+
+	var names []string
+	for i, name := range s.symbolTable.Names() {
+		if name != "" && s.globals[i] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	values := make([]object.Object, len(names))
+	for i, name := range names {
+		symbol, _ := s.symbolTable.Resolve(name)
+		values[i] = s.globals[symbol.Index]
+	}
+	return names, values
+}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+// completions returns the names the editor completes: the variables of the
+// session, the builtin functions, the keywords and the commands.
+func (s *session) completions() []string {
+	names, _ := s.bindings()
+	for _, b := range object.Builtins {
+		names = append(names, b.Name)
+	}
+	for keyword := range token.Keywords {
+		names = append(names, keyword)
+	}
+	return names
+}
+
+func (s *session) printParserErrors(src string, diags []diagnostic.Diagnostic) {
+	if !s.opts.Quiet {
+		io.WriteString(s.out, LOGO)
+		io.WriteString(s.out, "Woops! We ran into some monkey business here!\n")
+		io.WriteString(s.out, " parser errors:\n")
+	}
+	diagnostic.RenderAll(s.out, src, diags)
+}
+
+func printBanner(out io.Writer) {
+	username := "there"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	fmt.Fprintf(out, `
+Hello %s! This is the Typescript programing language!
+Feel free to type in command, or :help
+`,
+		username)
+}
+
+// readInput reads lines until they form a complete input, that is until
+// the brackets, braces and parentheses opened in them are closed.
+func readInput(r lineReader) (string, error) {
+	var lines []string
+	prompt := PROMPT
+	for {
+		line, err := r.readLine(prompt)
+		if err == io.EOF && len(lines) > 0 {
+			// let the parser report what is missing
+			return strings.Join(lines, "\n"), nil
+		}
+		if err != nil {
+			return "", err
 		}
+		lines = append(lines, line)
+		if src := strings.Join(lines, "\n"); !incomplete(src) {
+			return src, nil
+		}
+		prompt = CONTINUATION_PROMPT
 	}
 }
 
-func printParserErrors(out io.Writer, errs []string) {
-	io.WriteString(out, LOGO)
-	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errs {
-		io.WriteString(out, "\t"+msg+"\n")
+// incomplete reports whether src ends inside brackets, braces, parentheses,
+// a block comment or a raw string.
+func incomplete(src string) bool {
+	l := lexer.New(src, lexer.WithComments())
+	depth := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if strings.HasPrefix(tok.Literal, "/*") || strings.HasPrefix(tok.Literal, "`") {
+				return true
+			}
+		}
 	}
+	return depth > 0
+}
+
+func isBuiltin(name string) bool {
+	return object.GetBuiltinByName(name) != nil
 }
@@ -0,0 +1,224 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	input := `let double = fn(x) {
+  x * 2
+};
+double(
+  21
+)
+let a = [1,
+  2]; len(a)
+/* a comment
+spanning lines */ "done"
+`
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		var out bytes.Buffer
+		StartWithOptions(strings.NewReader(input), &out, Options{Engine: engine, Quiet: true})
+
+		expected := ">> .. .. >> .. .. 42\n>> .. 2\n>> .. done\n>> "
+		if got := out.String(); got != expected {
+			t.Errorf("wrong output of the %s engine. want=%q, got=%q", engine, expected, got)
+		}
+	}
+}
+
+var duration = regexp.MustCompile(`took \S+`)
+
+func TestCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.ts")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":type 1 + 1", "INTEGER\n"},
+		{":type \"a\"", "STRING\n"},
+		{"let a = 1; let s = \"x\" + \"y\"\n:env", "a: INTEGER = 1\ns: STRING = xy\n"},
+		{"let a = 1\n:reset\n:env\n:type a", "error[undefined]: undefined: a\n --> 1:1\n  |\n1 | a\n  | ^\n"},
+		{":ast -x", "Program 1:1\n  Statements[0]: ExpressionStatement 1:1\n    Expression: PrefixExpression - 1:1\n      Right: Identifier x 1:2\n"},
+		{":ast if (a) { 1 }", "Program 1:1\n" +
+			"  Statements[0]: ExpressionStatement 1:1\n" +
+			"    Expression: IfExpression 1:1\n" +
+			"      Condition: Identifier a 1:5\n" +
+			"      Consequence: BlockStatement 1:8\n" +
+			"        Statements[0]: ExpressionStatement 1:10\n" +
+			"          Expression: IntegerLiteral 1 1:10\n"},
+		{":tokens let x", "1:1      LET            \"let\"\n1:5      IDENT          \"x\"\n"},
+		{":time 1 + 2", "3\ntook <duration>\n"},
+		{"let x = 2\nlet y = z\nlet y = x * 3\n:save " + file + "\n:reset\n:load " + file + "\ny",
+			"error[undefined]: undefined: z\n --> 1:9\n  |\n1 | let y = z\n  |         ^\nsaved 2 inputs to " + file + "\n6\n"},
+		{"let f = fn() { helper() }\nlet helper = fn() { 7 }\nf()",
+			"warning[undefined]: undefined: helper\n --> 1:16\n  |\n1 | let f = fn() { helper() }\n  |                ^^^^^^\n7\n"},
+		{":load", "usage: :load <file>\n"},
+		{":nope", "unknown command :nope, see :help\n"},
+		{"1; let x = 2\n1; for (x in [2]) { x }", "null\n"},
+		{`"${fn() { 1 }}"`, "fn() {\n1\n}\n"},
+		{":quit\n1", ""},
+		{"exit()\n1", ""},
+	}
+
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			StartWithOptions(strings.NewReader(tt.input+"\n"), &out, Options{Engine: engine, Quiet: true})
+
+			got := strings.ReplaceAll(strings.ReplaceAll(out.String(), CONTINUATION_PROMPT, ""), PROMPT, "")
+			got = duration.ReplaceAllString(got, "took <duration>")
+			if got != tt.expected {
+				t.Errorf("wrong output of %q with the %s engine. want=%q, got=%q", tt.input, engine, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let a = 1", false},
+		{"fn(x) {", true},
+		{"fn(x) {\n x", true},
+		{"fn(x) {\n x\n}", false},
+		{"[1, [2,", true},
+		{"f(", true},
+		{"}", false},
+		{`"{"`, false},
+		{`"${ {"a": 1}["a"] }"`, false},
+		{"// {", false},
+		{"/* {", true},
+		{"/* { */", false},
+		{"let s = `a", true},
+		{"let s = `a\n{", true},
+		{"let s = `a\n{`", false},
+	}
+
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	names := []string{"first", "filter", "fn", "for", "flatten", "format", "x", "format"}
+	tests := []struct {
+		line     string
+		pos      int
+		expected string // the completed line, with | at the cursor
+		matches  []string
+	}{
+		{"fl", 2, "flatten|", []string{"flatten"}},
+		{"fi", 2, "fi|", []string{"filter", "first"}},
+		{"len(fo)", 6, "len(for|)", []string{"for", "format"}},
+		{"q", 1, "q|", nil},
+		{"1 + ", 4, "1 + |", nil},
+		{"x", 1, "x|", []string{"x"}},
+	}
+
+	for _, tt := range tests {
+		line, pos, matches := complete([]rune(tt.line), tt.pos, names)
+		got := string(line[:pos]) + "|" + string(line[pos:])
+		if got != tt.expected {
+			t.Errorf("wrong completion of %q. want=%q, got=%q", tt.line, tt.expected, got)
+		}
+		if strings.Join(matches, " ") != strings.Join(tt.matches, " ") {
+			t.Errorf("wrong matches for %q. want=%q, got=%q", tt.line, tt.matches, matches)
+		}
+	}
+}
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"abc\r", "abc"},
+		{"abc\x7f\x7fd\r", "ad"},
+		{"héllo\x1b[D\x1b[D\x7f\r", "hélo"},
+		{"world\x01hello \r", "hello world"},
+		{"one two\x17\r", "one "},
+		{"abc\x02\x02\x0b\r", "a"},
+		{"abc\x01\x04\r", "bc"},
+		{"abc\x1b[H\x1b[3~\x1b[Fd\r", "bcd"},
+		{"le\tx\r", "len(x"},
+		{"\x1b[A\r", "previous"},
+		{"new\x10\x10\x0e\x0e\r", "new"},
+		{"\x1b[A\x1b[A\x1b[A\x1b[A\r", "older"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		e := &editor{
+			in:      bufio.NewReader(strings.NewReader(tt.keys)),
+			out:     &out,
+			history: &history{lines: []string{"older", "previous"}},
+			names:   func() []string { return []string{"len("} },
+		}
+		line, err := e.edit(">> ")
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.keys, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("wrong line for %q. want=%q, got=%q", tt.keys, tt.expected, line)
+		}
+	}
+}
+
+func TestEditorEnd(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected error
+	}{
+		{"\x04", io.EOF},
+		{"abc\x03", errInterrupted},
+		{"abc", io.EOF},
+	}
+
+	for _, tt := range tests {
+		e := &editor{in: bufio.NewReader(strings.NewReader(tt.keys)), out: io.Discard, history: &history{}}
+		if _, err := e.edit(">> "); err != tt.expected {
+			t.Errorf("wrong error for %q. want=%v, got=%v", tt.keys, tt.expected, err)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	h := loadHistory(file)
+	for _, line := range []string{"a", "b", "b", " ", "c"} {
+		h.add(line)
+	}
+	if got := strings.Join(loadHistory(file).lines, " "); got != "a b c" {
+		t.Errorf("wrong history. want=%q, got=%q", "a b c", got)
+	}
+
+	var long strings.Builder
+	for i := 0; i < MaxHistory+10; i++ {
+		long.WriteString("line\n")
+	}
+	long.WriteString("last\n")
+	if err := os.WriteFile(file, []byte(long.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h = loadHistory(file)
+	if len(h.lines) != MaxHistory || h.lines[len(h.lines)-1] != "last" {
+		t.Errorf("history not cut to the most recent %d lines. got=%d", MaxHistory, len(h.lines))
+	}
+	if saved := loadHistory(file); len(saved.lines) != MaxHistory {
+		t.Errorf("cut history not saved. got=%d lines", len(saved.lines))
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package repl
+
+import "errors"
+
+// Line editing is only supported on Linux and macOS; elsewhere the REPL
+// reads plain lines.
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
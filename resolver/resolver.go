@@ -0,0 +1,283 @@
+// Package resolver binds the identifiers of a program to the variables
+// they refer to, before the program runs.
+//
+// Every function literal opens a scope; blocks do not, so a `let` in the
+// body of an if or a loop declares a variable of the enclosing function,
+// or of the program. A variable is visible from its declaration on, and in
+// every function defined in its scope, which may call functions declared
+// further down.
+package resolver
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/object"
+)
+
+// Diagnostic codes reported by the resolver.
+const (
+	CodeUndefined            = "undefined"
+	CodeDuplicateDeclaration = "duplicate-declaration"
+)
+
+// Resolve sets the Binding of every identifier in program, and the
+// NumLocals of every function literal. The variables of the program scope
+// are declared in env, which may already hold globals of the host or of
+// earlier programs. isBuiltin tells which names are builtin functions.
+//
+// Resolve reports references to undefined names and duplicate parameters
+// and loop variables as errors, and variables declared twice in the same
+// block as warnings. So are references in functions to names declared
+// nowhere, which a later program run in env may declare before the
+// functions are called, as in the REPL.
+func Resolve(program *ast.Program, env *object.Environment, isBuiltin func(name string) bool) []diagnostic.Diagnostic {
+	r := &resolver{env: env, isBuiltin: isBuiltin}
+	r.scopes = []*scope{{env: env}}
+	r.block(program.Statements)
+	r.resolvePending()
+	sort.SliceStable(r.diags, func(i, j int) bool {
+		return r.diags[i].Pos.Offset < r.diags[j].Pos.Offset
+	})
+	return r.diags
+}
+
+// scope is the program scope or the scope of a function literal.
+type scope struct {
+	env   *object.Environment // for the program scope
+	fn    *ast.FunctionLiteral
+	names map[string]int
+}
+
+func (s *scope) lookup(name string) (int, bool) {
+	if s.env != nil {
+		return s.env.Slot(name)
+	}
+	slot, ok := s.names[name]
+	return slot, ok
+}
+
+func (s *scope) declare(name string) int {
+	if s.env != nil {
+		return s.env.Declare(name)
+	}
+	if slot, ok := s.names[name]; ok {
+		return slot
+	}
+	slot := s.fn.NumLocals
+	s.names[name] = slot
+	s.fn.NumLocals++
+	return slot
+}
+
+// pending is a reference in a function literal to a name not declared yet
+// where it occurs, which may still be declared further down in one of the
+// scopes enclosing the function.
+type pending struct {
+	ident  *ast.Identifier
+	scope  *scope   // of ident
+	scopes []*scope // enclosing the scope of ident
+}
+
+type resolver struct {
+	env       *object.Environment
+	isBuiltin func(name string) bool
+	scopes    []*scope
+	pending   []pending
+	diags     []diagnostic.Diagnostic
+}
+
+// block resolves a list of statements, warning about variables declared
+// twice in it.
+func (r *resolver) block(stmts []ast.Statement) {
+	declared := make(map[string]*ast.Identifier)
+	for _, stmt := range stmts {
+		r.resolve(stmt)
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let.Name == nil {
+			continue
+		}
+		if prev, ok := declared[let.Name.Value]; ok {
+			r.report(diagnostic.Warning, let.Name, CodeDuplicateDeclaration, fmt.Sprintf("%s redeclared in this block", let.Name.Value),
+				fmt.Sprintf("previous declaration at %s", prev.Pos()))
+		} else {
+			declared[let.Name.Value] = let.Name
+		}
+	}
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+	case *ast.LetStatement:
+		// the value is resolved first, so `let x = x + 1` in a function
+		// refers to the x of an enclosing scope
+		r.resolve(node.Value)
+		r.declare(node.Name)
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+	case *ast.BlockStatement:
+		if node != nil {
+			r.block(node.Statements)
+		}
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.resolve(node.Body)
+	case *ast.ForStatement:
+		r.resolve(node.Iterable)
+		if node.Key != nil {
+			if node.Value != nil && node.Key.Value == node.Value.Value {
+				r.report(diagnostic.Error, node.Value, CodeDuplicateDeclaration, fmt.Sprintf("duplicate loop variable %s", node.Value.Value))
+			}
+			r.declare(node.Key)
+		}
+		r.declare(node.Value)
+		r.resolve(node.Body)
+	case *ast.Identifier:
+		r.reference(node)
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.AssignExpression:
+		r.resolve(node.Target)
+		r.resolve(node.Value)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Consequence)
+		r.resolve(node.Alternative)
+	case *ast.FunctionLiteral:
+		r.function(node)
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, arg := range node.Arguments {
+			r.resolve(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.resolve(el)
+		}
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			r.resolve(key)
+			r.resolve(node.Pairs[key])
+		}
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			r.resolve(part)
+		}
+	}
+}
+
+func (r *resolver) function(fn *ast.FunctionLiteral) {
+	fn.NumLocals = 0
+	r.scopes = append(r.scopes, &scope{fn: fn, names: make(map[string]int)})
+	declared := make(map[string]bool)
+	for _, param := range fn.Parameters {
+		if declared[param.Value] {
+			r.report(diagnostic.Error, param, CodeDuplicateDeclaration, fmt.Sprintf("duplicate parameter %s", param.Value))
+		}
+		declared[param.Value] = true
+		// parameters take the first slots, even if they are duplicates
+		slot := fn.NumLocals
+		r.top().names[param.Value] = slot
+		fn.NumLocals++
+		param.Binding = ast.Binding{Kind: ast.Variable, Slot: slot}
+	}
+	if fn.Body != nil {
+		r.resolve(fn.Body)
+	}
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *resolver) top() *scope {
+	return r.scopes[len(r.scopes)-1]
+}
+
+func (r *resolver) declare(ident *ast.Identifier) {
+	if ident == nil {
+		return
+	}
+	ident.Binding = ast.Binding{Kind: ast.Variable, Slot: r.top().declare(ident.Value)}
+}
+
+func (r *resolver) reference(ident *ast.Identifier) {
+	if binding, ok := lookup(r.scopes, ident.Value); ok {
+		ident.Binding = binding
+		return
+	}
+	if r.isBuiltin != nil && r.isBuiltin(ident.Value) {
+		ident.Binding = ast.Binding{Kind: ast.Builtin}
+		return
+	}
+	// a function may use names declared after it in enclosing scopes, as
+	// it runs later, but a name declared after its use in the same scope
+	// is used before it is declared
+	if len(r.scopes) == 1 {
+		r.undefined(ident, diagnostic.Error)
+		return
+	}
+	scopes := make([]*scope, len(r.scopes)-1)
+	copy(scopes, r.scopes)
+	r.pending = append(r.pending, pending{ident: ident, scope: r.top(), scopes: scopes})
+}
+
+// resolvePending resolves the references to names declared after them,
+// once all declarations are known.
+func (r *resolver) resolvePending() {
+	for _, p := range r.pending {
+		if binding, ok := lookup(p.scopes, p.ident.Value); ok {
+			// the depth counts from the scope of the reference
+			binding.Depth++
+			p.ident.Binding = binding
+			continue
+		}
+		if _, ok := p.scope.lookup(p.ident.Value); ok {
+			// declared after its use in the function
+			r.undefined(p.ident, diagnostic.Error)
+			continue
+		}
+		// a later program run in env may still declare the name before
+		// the function is called, and it is looked up by name then
+		r.undefined(p.ident, diagnostic.Warning)
+	}
+	r.pending = nil
+}
+
+// undefined reports a reference to a name not declared in its scopes.
+func (r *resolver) undefined(ident *ast.Identifier, severity diagnostic.Severity) {
+	ident.Binding = ast.Binding{}
+	if _, ok := r.env.Get(ident.Value); ok {
+		// bound by name in an environment enclosing env
+		return
+	}
+	r.report(severity, ident, CodeUndefined, fmt.Sprintf("undefined: %s", ident.Value))
+}
+
+// lookup finds name in the innermost of scopes declaring it.
+func lookup(scopes []*scope, name string) (ast.Binding, bool) {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if slot, ok := scopes[i].lookup(name); ok {
+			return ast.Binding{Kind: ast.Variable, Depth: len(scopes) - 1 - i, Slot: slot}, true
+		}
+	}
+	return ast.Binding{}, false
+}
+
+func (r *resolver) report(severity diagnostic.Severity, node ast.Node, code, msg string, hints ...string) {
+	r.diags = append(r.diags, diagnostic.Diagnostic{
+		Severity: severity,
+		Pos:      node.Pos(),
+		End:      node.End(),
+		Code:     code,
+		Message:  msg,
+		Hints:    hints,
+	})
+}
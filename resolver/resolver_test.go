@@ -0,0 +1,238 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func isBuiltin(name string) bool {
+	return object.GetBuiltinByName(name) != nil
+}
+
+// identifiers returns the identifiers of program named name, in source
+// order.
+func identifiers(node ast.Node, name string) []*ast.Identifier {
+	var found []*ast.Identifier
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, stmt := range node.Statements {
+				walk(stmt)
+			}
+		case *ast.BlockStatement:
+			for _, stmt := range node.Statements {
+				walk(stmt)
+			}
+		case *ast.LetStatement:
+			walk(node.Name)
+			walk(node.Value)
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.Identifier:
+			if node.Value == name {
+				found = append(found, node)
+			}
+		case *ast.FunctionLiteral:
+			for _, param := range node.Parameters {
+				walk(param)
+			}
+			walk(node.Body)
+		case *ast.CallExpression:
+			walk(node.Function)
+			for _, arg := range node.Arguments {
+				walk(arg)
+			}
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		}
+	}
+	walk(node)
+	return found
+}
+
+func TestBindings(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		expected []ast.Binding
+	}{
+		{
+			"let a = 1; a",
+			"a",
+			[]ast.Binding{{Kind: ast.Variable, Slot: 0}, {Kind: ast.Variable, Slot: 0}},
+		},
+		{
+			"let a = 1; let b = 2; fn(x) { fn() { b + x } }",
+			"b",
+			[]ast.Binding{{Kind: ast.Variable, Slot: 1}, {Kind: ast.Variable, Depth: 2, Slot: 1}},
+		},
+		{
+			"fn(x, y) { let z = 1; fn() { z } }",
+			"z",
+			[]ast.Binding{{Kind: ast.Variable, Slot: 2}, {Kind: ast.Variable, Depth: 1, Slot: 2}},
+		},
+		{
+			"let x = 1; fn() { let x = x + 1; x }",
+			"x",
+			[]ast.Binding{
+				{Kind: ast.Variable, Slot: 0},
+				{Kind: ast.Variable, Slot: 0},
+				{Kind: ast.Variable, Depth: 1, Slot: 0},
+				{Kind: ast.Variable, Slot: 0},
+			},
+		},
+		{
+			"let f = fn() { g() }; let g = 1",
+			"g",
+			[]ast.Binding{{Kind: ast.Variable, Depth: 1, Slot: 1}, {Kind: ast.Variable, Slot: 1}},
+		},
+		{
+			"let f = fn() { fn() { g } }; let g = 1",
+			"g",
+			[]ast.Binding{{Kind: ast.Variable, Depth: 2, Slot: 1}, {Kind: ast.Variable, Slot: 1}},
+		},
+		{
+			"fn() { fn() { y }; let y = 1 }",
+			"y",
+			[]ast.Binding{{Kind: ast.Variable, Depth: 1, Slot: 0}, {Kind: ast.Variable, Slot: 0}},
+		},
+		{
+			"len([])",
+			"len",
+			[]ast.Binding{{Kind: ast.Builtin}},
+		},
+		{
+			"let len = fn(x) { 0 }; len([])",
+			"len",
+			[]ast.Binding{{Kind: ast.Variable, Slot: 0}, {Kind: ast.Variable, Slot: 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		if diags := Resolve(program, object.NewEnvironment(), isBuiltin); len(diags) != 0 {
+			t.Errorf("unexpected diagnostics for %q: %v", tt.input, diags)
+			continue
+		}
+		idents := identifiers(program, tt.name)
+		if len(idents) != len(tt.expected) {
+			t.Errorf("wrong number of %s in %q. want=%d, got=%d", tt.name, tt.input, len(tt.expected), len(idents))
+			continue
+		}
+		for i, ident := range idents {
+			if ident.Binding != tt.expected[i] {
+				t.Errorf("wrong binding of %s #%d in %q. want=%+v, got=%+v",
+					tt.name, i, tt.input, tt.expected[i], ident.Binding)
+			}
+		}
+	}
+}
+
+func TestNumLocals(t *testing.T) {
+	program := parse(t, "fn(a, b) { let c = 1; if (a) { let d = 2 }; for (k, v in b) {}; let c = 3; fn(e) { e } }")
+	Resolve(program, object.NewEnvironment(), isBuiltin)
+
+	outer := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if outer.NumLocals != 6 {
+		t.Errorf("wrong NumLocals of the outer function. want=6, got=%d", outer.NumLocals)
+	}
+	stmts := outer.Body.Statements
+	inner := stmts[len(stmts)-1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if inner.NumLocals != 1 {
+		t.Errorf("wrong NumLocals of the inner function. want=1, got=%d", inner.NumLocals)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("host", &object.Integer{Value: 1})
+
+	program := parse(t, "let x = host; x")
+	if diags := Resolve(program, env, isBuiltin); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	slot, ok := env.Slot("x")
+	if !ok || slot != 1 {
+		t.Errorf("x not declared in the environment after host. got=%d, %t", slot, ok)
+	}
+
+	// a later program sees the globals of earlier ones
+	if diags := Resolve(parse(t, "x + host"), env, isBuiltin); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+
+	// and may declare the names functions of earlier ones use
+	program = parse(t, "let f = fn() { later }")
+	if diags := Resolve(program, env, isBuiltin); len(diags) != 1 || diags[0].Severity != diagnostic.Warning {
+		t.Errorf("expected a warning for later, got=%v", diags)
+	}
+	if diags := Resolve(parse(t, "let later = 1; f()"), env, isBuiltin); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // "severity line:column message"
+	}{
+		{"x", []string{"error 1:1 undefined: x"}},
+		{"let f = fn() { y }; f()", []string{"warning 1:16 undefined: y"}},
+		{"x = 1", []string{"error 1:1 undefined: x"}},
+		{"puts(y); let y = 1", []string{"error 1:6 undefined: y"}},
+		{"let f = fn() { puts(y); let y = 1 }", []string{"error 1:21 undefined: y"}},
+		{"while (false) { y; let y = 1 }", []string{"error 1:17 undefined: y"}},
+		{"let f = fn(a, b, a) { a }", []string{"error 1:18 duplicate parameter a"}},
+		{"for (i, i in [1]) {}", []string{"error 1:9 duplicate loop variable i"}},
+		{"let x = 1;\nlet x = 2;", []string{"warning 2:5 x redeclared in this block"}},
+		{"let f = fn() { let a = b; let a = c }", []string{
+			"warning 1:24 undefined: b",
+			"warning 1:31 a redeclared in this block",
+			"warning 1:35 undefined: c",
+		}},
+		{"let x = 1; if (x) { let x = 2 } else { let x = 3 }", nil},
+		{"let f = fn() { g() }; let g = fn() { f() }", nil},
+		{"puts(len([]))", nil},
+	}
+
+	for _, tt := range tests {
+		diags := Resolve(parse(t, tt.input), object.NewEnvironment(), isBuiltin)
+		if len(diags) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%v", tt.input, len(tt.expected), diags)
+			continue
+		}
+		for i, d := range diags {
+			got := d.Severity.String() + " " + d.Pos.String() + " " + d.Message
+			if got != tt.expected[i] {
+				t.Errorf("wrong diagnostic for %q. want=%q, got=%q", tt.input, tt.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestDuplicateHint(t *testing.T) {
+	diags := Resolve(parse(t, "let x = 1; let x = 2"), object.NewEnvironment(), isBuiltin)
+	if len(diags) != 1 || diags[0].Severity != diagnostic.Warning || diags[0].Code != CodeDuplicateDeclaration {
+		t.Fatalf("expected a duplicate declaration warning, got=%v", diags)
+	}
+	if len(diags[0].Hints) != 1 || diags[0].Hints[0] != "previous declaration at 1:5" {
+		t.Errorf("wrong hints. got=%q", diags[0].Hints)
+	}
+}
@@ -0,0 +1,216 @@
+// Package tinyscript embeds the tinyscript language in Go programs.
+//
+//	in := tinyscript.New()
+//	in.Define("limit", 10)
+//	in.RegisterFunc("double", func(n int) int { return 2 * n })
+//	result, err := in.Run(ctx, "double(limit)")
+//
+// Every Interpreter has its own global variables and builtin functions, so
+// several of them can be used side by side.
+package tinyscript
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/startdusk/tinyscript/diagnostic"
+	"github.com/startdusk/tinyscript/evaluator"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/parser"
+	"github.com/startdusk/tinyscript/resolver"
+)
+
+// Limits bounds the resources a Run or Call may use, see WithLimits.
+type Limits = evaluator.Limits
+
+// ErrLimitExceeded is wrapped by the errors of a Run or Call that exceeds
+// its Limits:
+//
+//	if errors.Is(err, tinyscript.ErrLimitExceeded) { ... }
+var ErrLimitExceeded = evaluator.ErrLimitExceeded
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithStdout sets where the puts builtin writes to, os.Stdout by default.
+func WithStdout(w io.Writer) Option {
+	return func(in *Interpreter) {
+		in.stdout = w
+	}
+}
+
+// WithLimits bounds the resources of every Run and Call.
+func WithLimits(limits Limits) Option {
+	return func(in *Interpreter) {
+		in.limits = limits
+	}
+}
+
+// WithFilename sets the filename reported in syntax errors and stack
+// traces.
+func WithFilename(filename string) Option {
+	return func(in *Interpreter) {
+		in.filename = filename
+	}
+}
+
+// Interpreter runs scripts with the tree-walking evaluator. Variables
+// defined by one Run are visible to the next ones. An Interpreter must not
+// be used by several goroutines at once.
+type Interpreter struct {
+	stdout   io.Writer
+	filename string
+	limits   Limits
+	env      *object.Environment
+	builtins map[string]*object.Builtin
+	eval     *evaluator.Evaluator
+}
+
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{
+		stdout:   os.Stdout,
+		env:      object.NewEnvironment(),
+		builtins: make(map[string]*object.Builtin),
+	}
+	for _, opt := range opts {
+		opt(in)
+	}
+
+	for _, def := range object.Builtins {
+		in.builtins[def.Name] = def.Builtin
+	}
+	in.builtins["puts"] = &object.Builtin{Fn: in.puts}
+	in.eval = evaluator.NewWithBuiltins(in.builtins)
+	in.eval.SetLimits(in.limits)
+	return in
+}
+
+// Define binds name to value as a global variable of the scripts. The
+// value is converted by object.FromGo.
+func (in *Interpreter) Define(name string, value any) error {
+	obj, err := object.FromGo(value)
+	if err != nil {
+		return fmt.Errorf("tinyscript: define %s: %w", name, err)
+	}
+	in.env.Set(name, obj)
+	return nil
+}
+
+// RegisterFunc makes fn callable by the scripts as the builtin function
+// name, replacing any builtin of that name. fn is either an
+// object.BuiltinFunction or any Go func, whose arguments and results are
+// converted as described by object.FromGo. Scripts can pass their
+// functions for func arguments, like callbacks.
+func (in *Interpreter) RegisterFunc(name string, fn any) error {
+	obj, err := object.FromGo(fn)
+	if err != nil {
+		return fmt.Errorf("tinyscript: register %s: %w", name, err)
+	}
+	builtin, ok := obj.(*object.Builtin)
+	if !ok {
+		return fmt.Errorf("tinyscript: register %s: not a function: %T", name, fn)
+	}
+	in.builtins[name] = builtin
+	return nil
+}
+
+// Run parses and evaluates src and returns the value of its last
+// statement. A script that does not parse or refers to undefined names
+// fails with a *SyntaxError, and one raising a runtime error with an
+// *object.Error. So does a script stopped because ctx is done or a limit
+// is exceeded, with ctx.Err() or ErrLimitExceeded as its cause, and one
+// calling exit with an *object.ExitError as its cause.
+func (in *Interpreter) Run(ctx context.Context, src string) (object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(src, lexer.WithFilename(in.filename)))
+	program := p.ParseProgram()
+	if diags := p.Diagnostics(); len(diags) != 0 {
+		return nil, &SyntaxError{Source: src, Diagnostics: diags}
+	}
+	if diags := resolver.Resolve(program, in.env, in.isBuiltin); diagnostic.HasErrors(diags) {
+		return nil, &SyntaxError{Source: src, Diagnostics: errorsOf(diags)}
+	}
+	return result(in.eval.EvalContext(ctx, program, in.env))
+}
+
+// Call calls the function bound to name, either a script function defined
+// by an earlier Run or a builtin, with args converted by object.FromGo.
+func (in *Interpreter) Call(ctx context.Context, name string, args ...any) (object.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := object.FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("tinyscript: call %s: argument %d: %w", name, i+1, err)
+		}
+		objs[i] = obj
+	}
+
+	fn, ok := in.env.Get(name)
+	if !ok {
+		builtin, ok := in.builtins[name]
+		if !ok {
+			return nil, fmt.Errorf("tinyscript: function not found: %s", name)
+		}
+		fn = builtin
+	}
+	return result(in.eval.ApplyContext(ctx, fn, objs...))
+}
+
+func (in *Interpreter) isBuiltin(name string) bool {
+	_, ok := in.builtins[name]
+	return ok
+}
+
+func errorsOf(diags []diagnostic.Diagnostic) []diagnostic.Diagnostic {
+	var errs []diagnostic.Diagnostic
+	for _, d := range diags {
+		if d.Severity == diagnostic.Error {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+func result(obj object.Object) (object.Object, error) {
+	switch obj := obj.(type) {
+	case nil:
+		return evaluator.NULL, nil
+	case *object.Error:
+		return nil, obj
+	default:
+		return obj, nil
+	}
+}
+
+func (in *Interpreter) puts(args ...object.Object) object.Object {
+	for _, arg := range args {
+		fmt.Fprintln(in.stdout, arg.Inspect())
+	}
+	return nil
+}
+
+// SyntaxError is returned by Run for a script that does not parse or does
+// not resolve.
+type SyntaxError struct {
+	Source      string
+	Diagnostics []diagnostic.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,261 @@
+package tinyscript
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/startdusk/tinyscript/object"
+)
+
+func TestInterpreterRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2", "3"},
+		{`let greet = fn(name) { "hello " + name }; greet("Ann")`, "hello Ann"},
+		{"let x = 1;", "null"},
+		{"", "null"},
+		{"limit * 2", "20"},
+		{"double(limit)", "20"},
+		{`double("x")`, "ERROR: want INTEGER"},
+		{"let twice = fn() { helper() * 2 }", "null"},
+		{"let helper = fn() { 21 }", "null"},
+		{"twice()", "42"},
+	}
+
+	in := New()
+	in.Define("limit", &object.Integer{Value: 10})
+	in.RegisterFunc("double", func(args ...object.Object) object.Object {
+		i, ok := args[0].(*object.Integer)
+		if !ok {
+			return &object.Error{Message: "want INTEGER"}
+		}
+		return &object.Integer{Value: 2 * i.Value}
+	})
+
+	for _, tt := range tests {
+		result, err := in.Run(context.Background(), tt.input)
+		var errObj *object.Error
+		if errors.As(err, &errObj) {
+			result = errObj
+		} else if err != nil {
+			t.Fatalf("Run(%q) failed: %s", tt.input, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("Run(%q) wrong. want=%q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestInterpreterGoValues(t *testing.T) {
+	type user struct {
+		Name  string `tinyscript:"name"`
+		Admin bool   `tinyscript:"admin"`
+	}
+
+	in := New()
+	for name, value := range map[string]any{
+		"users":   []user{{"ann", true}, {"bob", false}},
+		"enabled": false,
+		"nothing": nil,
+	} {
+		if err := in.Define(name, value); err != nil {
+			t.Fatalf("Define(%s) failed: %s", name, err)
+		}
+	}
+	if err := in.RegisterFunc("greet", func(u user) string { return "hi " + u.Name }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := in.RegisterFunc("fail", func() error { return errors.New("failed") }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := in.RegisterFunc("take", func(v any) int { return 1 }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := in.RegisterFunc("apply", func(f func(int) int, n int) int { return f(n) }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := in.RegisterFunc("try", func(f func(int) (int, error), n int) (int, error) { return f(n) }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+	if err := in.RegisterFunc("x", 1); err == nil {
+		t.Errorf("expected an error registering a non-function")
+	}
+	if err := in.Define("ch", make(chan int)); err == nil {
+		t.Errorf("expected an error defining a channel")
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`greet(users[0])`, "hi ann"},
+		{`if (users[1]["admin"]) { 1 } else { 2 }`, "2"},
+		{`if (enabled || nothing) { 1 } else { 2 }`, "2"},
+		{`!enabled`, "true"},
+		{`fail()`, "ERROR: failed"},
+		{`let a = [1]; a[0] = a; take(a)`, "ERROR: argument 1: cannot convert ARRAY holding itself"},
+		{`let h = {}; h["h"] = [h]; take(h)`, "ERROR: argument 1: cannot convert HASH holding itself"},
+		{`let b = [1]; take([b, b])`, "1"},
+		{`apply(fn(x) { x + 1 }, 1)`, "2"},
+		{`let k = 10; let add = fn(x) { x + k }; apply(add, 1)`, "11"},
+		{`apply(fn(x) { apply(fn(y) { y * 2 }, x) }, 4)`, "8"},
+		{`try(fn(x) { x / 0 }, 1)`, "ERROR: division by zero"},
+		{`try(fn(x) { "a" }, 1)`, "ERROR: cannot convert STRING to int"},
+		{`apply(1, 1)`, "ERROR: argument 1: cannot convert INTEGER to func(int) int"},
+	}
+	for _, tt := range tests {
+		result, err := in.Run(context.Background(), tt.input)
+		var errObj *object.Error
+		if errors.As(err, &errObj) {
+			result = errObj
+		} else if err != nil {
+			t.Fatalf("Run(%q) failed: %s", tt.input, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("Run(%q) wrong. want=%q, got=%q", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	result, err := in.Call(context.Background(), "greet", user{Name: "cy"})
+	if err != nil || result.Inspect() != "hi cy" {
+		t.Errorf("Call wrong. want=%q, got=%v (%v)", "hi cy", result, err)
+	}
+}
+
+func TestInterpreterCall(t *testing.T) {
+	in := New()
+	ctx := context.Background()
+	if _, err := in.Run(ctx, "let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	result, err := in.Call(ctx, "add", 1, 2)
+	if err != nil {
+		t.Fatalf("Call failed: %s", err)
+	}
+	if result.Inspect() != "3" {
+		t.Errorf("wrong result. want=3, got=%s", result.Inspect())
+	}
+
+	result, err = in.Call(ctx, "len", "four")
+	if err != nil || result.Inspect() != "4" {
+		t.Errorf("wrong builtin result. want=4, got=%v (%v)", result, err)
+	}
+
+	_, err = in.Call(ctx, "add", 1, "x")
+	if err == nil || err.Error() != "type mismatch: INTEGER + STRING" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	if _, err := in.Call(ctx, "missing"); err == nil {
+		t.Errorf("expected an error calling an undefined function")
+	}
+}
+
+func TestInterpreterIsolation(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	in1 := New(WithStdout(&out1))
+	in2 := New(WithStdout(&out2))
+	in1.RegisterFunc("len", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: -1}
+	})
+	ctx := context.Background()
+
+	if _, err := in1.Run(ctx, `let x = 1; puts(len("ab"))`); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if _, err := in2.Run(ctx, `puts(len("ab"))`); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if _, err := in2.Run(ctx, "x"); err == nil {
+		t.Errorf("variable leaked from another interpreter")
+	}
+
+	if out1.String() != "-1\n" {
+		t.Errorf("wrong output of first interpreter. got=%q", out1.String())
+	}
+	if out2.String() != "2\n" {
+		t.Errorf("wrong output of second interpreter. got=%q", out2.String())
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	in := New(WithFilename("rules.ts"))
+
+	_, err := in.Run(context.Background(), "let x 5;")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a *SyntaxError, got=%T (%v)", err, err)
+	}
+	if err.Error() != `rules.ts:1:7: expected "=", got "5"` {
+		t.Errorf("wrong error message. got=%q", err.Error())
+	}
+
+	_, err = in.Run(context.Background(), "let f = fn(a, a) { b }; c")
+	if !errors.As(err, &syntaxErr) || len(syntaxErr.Diagnostics) != 2 {
+		t.Fatalf("expected a *SyntaxError with 2 diagnostics, got=%v", err)
+	}
+	if syntaxErr.Diagnostics[1].Error() != "rules.ts:1:25: undefined: c" {
+		t.Errorf("wrong diagnostic. got=%q", syntaxErr.Diagnostics[1].Error())
+	}
+	if _, err := in.Run(context.Background(), "let x = 1; let x = 2; x"); err != nil {
+		t.Errorf("a redeclaration should only be a warning, got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := in.Run(ctx, "1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got=%v", err)
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	in := New(WithLimits(Limits{MaxSteps: 10000}))
+	_, err := in.Run(context.Background(), "let loop = fn() { while (true) {} }; loop()")
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got=%v", err)
+	}
+	if _, err := in.Call(context.Background(), "loop"); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded from Call, got=%v", err)
+	}
+
+	// the budget is per run
+	for i := 0; i < 3; i++ {
+		if _, err := in.Run(context.Background(), "let i = 0; while (i < 1000) { i += 1 }"); err != nil {
+			t.Fatalf("run %d failed: %s", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = New().Run(ctx, "while (true) {}")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got=%v", err)
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("a done context is not a limit")
+	}
+}
+
+func TestInterpreterExitAndPanic(t *testing.T) {
+	in := New()
+	if err := in.RegisterFunc("boom", func() { panic("boom") }); err != nil {
+		t.Fatalf("RegisterFunc failed: %s", err)
+	}
+
+	_, err := in.Run(context.Background(), "boom()")
+	if err == nil || err.Error() != "panic: boom" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	_, err = in.Run(context.Background(), "let f = fn() { exit(4) }; f(); puts(1)")
+	var exit *object.ExitError
+	if !errors.As(err, &exit) || exit.Code != 4 {
+		t.Errorf("expected exit status 4, got=%v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package vm
+
+import "github.com/startdusk/tinyscript/object"
+
+// cell boxes a local variable that has been captured by a closure, so that
+// assignments through either the closure or the enclosing function are
+// visible to both. OpGetLocal and OpGetFree unwrap cells transparently.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+
+func (c *cell) Inspect() string { return c.value.Inspect() }
+
+// deref returns the value held by obj if it is a cell, or obj itself.
+func deref(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.value
+	}
+	return obj
+}
+
+// store sets the variable in slot to val, through its cell if it has one.
+func store(slot *object.Object, val object.Object) {
+	if c, ok := (*slot).(*cell); ok {
+		c.value = val
+		return
+	}
+	*slot = val
+}
@@ -0,0 +1,33 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/startdusk/tinyscript/code"
+	"github.com/startdusk/tinyscript/object"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+func (f *Frame) localName(index int) string {
+	if names := f.cl.Fn.LocalNames; index < len(names) {
+		return names[index]
+	}
+	return fmt.Sprintf("local #%d", index)
+}
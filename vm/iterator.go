@@ -0,0 +1,25 @@
+package vm
+
+import "github.com/startdusk/tinyscript/object"
+
+// iterator walks the pairs of object.Iterate for a for loop. It only
+// lives on the stack of a running loop.
+type iterator struct {
+	keys   []object.Object
+	values []object.Object
+	next   int
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+
+func (it *iterator) Inspect() string { return "iterator" }
+
+// newIterator returns an iterator over obj for a loop with numVars
+// variables.
+func newIterator(obj object.Object, numVars int) (*iterator, error) {
+	keys, values, ok := object.Iterate(obj, numVars == 1)
+	if !ok {
+		return nil, newError("not iterable: %s", obj.Type())
+	}
+	return &iterator{keys: keys, values: values}, nil
+}
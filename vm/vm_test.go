@@ -0,0 +1,811 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/startdusk/tinyscript/ast"
+	"github.com/startdusk/tinyscript/compiler"
+	"github.com/startdusk/tinyscript/lexer"
+	"github.com/startdusk/tinyscript/object"
+	"github.com/startdusk/tinyscript/parser"
+)
+
+// The tests below mirror the evaluator's test suite, so that both engines
+// are held to the same behaviour.
+
+type vmTestCase struct {
+	input    string
+	expected any
+}
+
+// vmError is the expected message of a runtime error.
+type vmError string
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testRun(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		t.Fatalf("vm error: %s", err)
+	}
+
+	return vm.LastPoppedStackElem()
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		testExpectedObject(t, tt.input, tt.expected, testRun(t, tt.input))
+	}
+}
+
+func testExpectedObject(t *testing.T, input string, expected any, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, input, int64(expected), actual)
+	case float64:
+		testFloatObject(t, input, expected, actual)
+	case bool:
+		testBooleanObject(t, input, expected, actual)
+	case string:
+		testStringObject(t, input, expected, actual)
+	case nil:
+		if actual != Null {
+			t.Errorf("%s: object is not Null. got=%T (%+v)", input, actual, actual)
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("%s: object not Array. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("%s: wrong num of elements. want=%d, got=%d",
+				input, len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			testIntegerObject(t, input, int64(expectedElem), array.Elements[i])
+		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("%s: object is not Hash. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if hash.Len() != len(expected) {
+			t.Errorf("%s: hash has wrong number of Pairs. want=%d, got=%d",
+				input, len(expected), hash.Len())
+			return
+		}
+		pairs := make(map[object.HashKey]object.Object)
+		for _, pair := range hash.Pairs() {
+			pairs[pair.Key.(object.Hashable).HashKey()] = pair.Value
+		}
+		for expectedKey, expectedValue := range expected {
+			value, ok := pairs[expectedKey]
+			if !ok {
+				t.Errorf("%s: no pair for given key in Pairs", input)
+				continue
+			}
+			testIntegerObject(t, input, expectedValue, value)
+		}
+	case vmError:
+		errObj, ok := actual.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if errObj.Message != string(expected) {
+			t.Errorf("%s: wrong error message. expected=%q, got=%q",
+				input, expected, errObj.Message)
+		}
+	default:
+		t.Fatalf("%s: unsupported expectation %T", input, expected)
+	}
+}
+
+func testIntegerObject(t *testing.T, input string, expected int64, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		t.Errorf("%s: object is not Integer. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%s: object has wrong value. got=%d, want=%d", input, result.Value, expected)
+	}
+}
+
+func testFloatObject(t *testing.T, input string, expected float64, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Float)
+	if !ok {
+		t.Errorf("%s: object is not Float. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%s: object has wrong value. got=%g, want=%g", input, result.Value, expected)
+	}
+}
+
+func testBooleanObject(t *testing.T, input string, expected bool, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		t.Errorf("%s: object is not Boolean. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%s: object has wrong value. got=%t, want=%t", input, result.Value, expected)
+	}
+}
+
+func testStringObject(t *testing.T, input string, expected string, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.String)
+	if !ok {
+		t.Errorf("%s: object is not String. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%s: object has wrong value. got=%q, want=%q", input, result.Value, expected)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"5", 5},
+		{"-5", -5},
+		{"10", 10},
+		{"-10", -10},
+		{"5 + 5 + 5 + 5 - 10", 10},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 + -50", 0},
+		{"5 * 2 + 10", 20},
+		{"5 + 2 * 10", 25},
+		{"20 + 2 * -10", 0},
+		{"50 / 2 * 2 + 10", 60},
+		{"2 * (5 + 10)", 30},
+		{"3 * 3 * 3 + 10", 37},
+		{"3 * (3 * 3) + 10", 37},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 < 1", false},
+		{"1 > 1", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 2", false},
+		{"1 != 2", true},
+		{"true == true", true},
+		{"false == false", true},
+		{"true == false", false},
+		{"true != false", true},
+		{"false != true", true},
+		{"(1 < 2) == true", true},
+		{"(1 < 2) == false", false},
+		{"(1 > 2) == true", false},
+		{"(1 > 2) == false", true},
+		{"!true", false},
+		{"!false", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!!false", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" >= "b"`, true},
+		{`"a" <= "B"`, false},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [1, 2, 3]", false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{"[1, 2] != [2, 1]", true},
+		{"[] == []", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{"1 == 1.0", true},
+		{"[1] == [1.0]", true},
+		{`1 == "1"`, false},
+		{`[1] == {}`, false},
+		{`"" != []`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 > 2) { 10 }", nil},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestReturnStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 10;", 10},
+		{"return 10; 9;", 10},
+		{"return 2 * 5; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{`if (10 > 1) {
+			if (10 > 1) {
+			return 10;
+			}
+			return 1;
+			}`, 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestErrorHandling(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true;", vmError("type mismatch: INTEGER + BOOLEAN")},
+		{"5 + true; 5;", vmError("type mismatch: INTEGER + BOOLEAN")},
+		{"-true", vmError("unknown operator: -BOOLEAN")},
+		{`"a ${-true} b"`, vmError("unknown operator: -BOOLEAN")},
+		{"true + false;", vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{"5; true + false; 5", vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{"if (10 > 1) { true + false; }", vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{`if (10 > 1) {
+				if (10 > 1) {
+				return true + false;
+				}
+				return 1;
+				}`, vmError("unknown operator: BOOLEAN + BOOLEAN")},
+		{"foobar", vmError("identifier not found: foobar")},
+		{`"Hello" - "World"`, vmError("unknown operator: STRING - STRING")},
+		{`"a" < 1`, vmError("type mismatch: STRING < INTEGER")},
+		{"[1] < [2]", vmError("unknown operator: ARRAY < ARRAY")},
+		{`{"name": "Monkey"}[fn(x) { x }];`, vmError("unusable as hash key: FUNCTION")},
+		{"let f = fn() { g() }; f()", vmError("identifier not found: g")},
+		{"1(2)", vmError("not a function: INTEGER")},
+		{"fn(a, b) { a }(1)", vmError("wrong number of arguments: want=2, got=1")},
+		{"5 / 0", vmError("division by zero")},
+		{"let x = 5; x %= 0", vmError("division by zero")},
+		{"exit(3); 1", vmError("exit status 3")},
+		{"true < 1", vmError("type mismatch: BOOLEAN < INTEGER")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 5; a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a = 5; let b = a; b;", 5},
+		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+		{"let a = 1; let a = a + 1; a", 2},
+		{"let x = 1; let f = fn() { let x = x + 1; x }; f()", 2},
+		{"let x = 1; let f = fn() { let g = fn() { let x = x * 3; x }; let x = x + 1; x + g() }; f()", 5},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) + 1 } }; g(3) }; f()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionObject(t *testing.T) {
+	result := testRun(t, `fn(x) { x + 2; };`)
+	closure, ok := result.(*object.Closure)
+	if !ok {
+		t.Fatalf("object is not Closure. got=%T(%+v)", result, result)
+	}
+	if closure.Fn.NumParameters != 1 {
+		t.Fatalf("function has wrong parameters. got=%d", closure.Fn.NumParameters)
+	}
+}
+
+func TestFunctionApplication(t *testing.T) {
+	tests := []vmTestCase{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let identity = fn(x) { return x; }; identity(5);", 5},
+		{"let double = fn(x) { x * 2; }; double(5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"fn(x) { x; }(5)", 5},
+		{"let noReturn = fn() { }; noReturn();", nil},
+		{"let f = fn() { let a = 1; let b = 2; a + b }; f()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let newAdder = fn(x) {
+		fn(y) { x + y };
+		};
+		let addTwo = newAdder(2);
+		addTwo(2);`, 4},
+		{`
+		let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) {
+				let e = d + c;
+				fn(f) { e + f; };
+			};
+		};
+		let newAdderInner = newAdderOuter(1, 2)
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) { return 0; }
+			if (x == 1) { return 1; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) { return 0; }
+				countDown(x - 1);
+			};
+			countDown(1);
+		};
+		wrapper();`, 0},
+		{`
+		let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		isEven(10);`, true},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(9999)", 0},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10000)",
+			vmError("stack overflow: more than 10000 nested calls")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"Hello World"`, "Hello World"},
+		{`"Hello" + " " + "World"`, "Hello World"},
+		{`"caf\u00e9\t" + "\"q\""`, "café\t\"q\""},
+		{"`raw\\n\nline`", "raw\\n\nline"},
+		{`let größe = "ü"; größe + größe`, "üü"},
+		{`let name = "Ann"; "hello ${name}!"`, "hello Ann!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1, 2]"},
+		{`"${1 + 2}${true}${2.5}"`, "3true2.5"},
+		{`let f = fn(s) { s + "!" }; "${f("}")}"`, "}!"},
+		{`let x = 1; "a ${"b ${x}"} c"`, "a b 1 c"},
+		{`"${fn(x, y) { x + y }}"`, "fn(x, y) {\n(x + y)\n}"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`len(1)`, vmError("argument to `len` not supported, got INTEGER")},
+		{`len("one", "two")`, vmError("wrong number of arguments. got=2, want=1")},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`push([], 1)`, []int{1}},
+		{`puts("hello", "world!")`, nil},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3}`, `{b: 1, a: 2, 3: 3}`},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, `{b: 4, a: 2, c: 3}`},
+		{`keys({"z": 1, "y": 2, "x": 3})`, `[z, y, x]`},
+		{`values({"z": 1, "y": 2, "x": 3})`, `[1, 2, 3]`},
+		{`entries({"a": 1, 2: "b"})`, `[[a, 1], [2, b]]`},
+		{`len({"a": 1, "b": 2})`, `2`},
+		{`len({})`, `0`},
+		{`has({"a": 1}, "a")`, `true`},
+		{`has({"a": 1}, "b")`, `false`},
+		{`!has({"a": 1}, "b")`, `true`},
+		{`has({1: 1}, 1.0)`, `true`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b")`, `2`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "b"); h["d"] = 4; h`, `{a: 1, c: 3, d: 4}`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; delete(h, "a"); h["c"]`, `3`},
+		{`delete({}, "x")`, `null`},
+		{`let h = {}; set(h, "a", 1); set(h, "b", 2); set(h, "a", 3); h`, `{a: 3, b: 2}`},
+		{`let a = {"x": 1, "y": 2}; let b = {"y": 3, "z": 4}; merge(a, b)`, `{x: 1, y: 3, z: 4}`},
+		{`let a = {"x": 1}; merge(a, {"y": 2}); a`, `{x: 1}`},
+		{`let s = ""; for (k, v in {"c": 1, "a": 2, "b": 3}) { s += k }; s`, `cab`},
+		{`keys(1)`, "ERROR: argument to `keys` must be HASH, got INTEGER"},
+		{`has({}, [])`, "ERROR: unusable as hash key: ARRAY"},
+		{`set({}, "a")`, "ERROR: wrong number of arguments. got=2, want=3"},
+		{`merge()`, "ERROR: wrong number of arguments. got=0, want=1 or more"},
+	}
+
+	for _, tt := range tests {
+		if got := testRun(t, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, `[2, 4, 6]`},
+		{`map([], fn(x) { x })`, `[]`},
+		{`map(["a", "bc"], len)`, `[1, 2]`},
+		{`let k = 10; map([1, 2], fn(x) { x + k })`, `[11, 12]`},
+		{`map([[1, 2], [3]], fn(a) { map(a, fn(x) { x * x }) })`, `[[1, 4], [9]]`},
+		{`map([1], fn(x) { if (false) { 1 } })`, `[null]`},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, `[2, 4]`},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x })`, `6`},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)`, `16`},
+		{`reduce([], fn(acc, x) { acc + x })`, `null`},
+		{`let s = 0; each([1, 2, 3], fn(x) { s = s + x }); s`, `6`},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, `2`},
+		{`find([1, 2, 3], fn(x) { x > 3 })`, `null`},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, `true`},
+		{`any([])`, `false`},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, `true`},
+		{`all([1, false])`, `false`},
+		{`sort([3, 1.5, 2])`, `[1.5, 2, 3]`},
+		{`sort(["b", "c", "a"])`, `[a, b, c]`},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, `[3, 2, 1]`},
+		{`sort([[2, "b"], [1, "a"], [2, "a"]], fn(a, b) { a[0] - b[0] })`, `[[1, a], [2, b], [2, a]]`},
+		{`let a = [2, 1]; sort(a); a`, `[2, 1]`},
+		{`reverse([1, 2, 3])`, `[3, 2, 1]`},
+		{`reverse("héllo")`, `olléh`},
+		{`zip([1, 2, 3], ["a", "b"])`, `[[1, a], [2, b]]`},
+		{`range(4)`, `[0, 1, 2, 3]`},
+		{`range(1, 4)`, `[1, 2, 3]`},
+		{`range(5, 0, -2)`, `[5, 3, 1]`},
+		{`range(0)`, `[]`},
+		{`range(9223372036854775806, 9223372036854775807, 2)`, `[9223372036854775806]`},
+		{`range(-9223372036854775807 - 1, 9223372036854775807, 9223372036854775807)`, `[-9223372036854775808, -1, 9223372036854775806]`},
+		{`range(9223372036854775807, -9223372036854775807 - 1, -9223372036854775807 - 1)`, `[9223372036854775807, -1]`},
+		{`flatten([1, [2, [3]], []])`, `[1, 2, [3]]`},
+		{`flatten([1, [2, [3]]], 2)`, `[1, 2, 3]`},
+		{`let a = [1, [2]]; flatten([a, a], 2)`, `[1, 2, 1, 2]`},
+		{`let a = [1]; a[0] = a; flatten(a, 1000000)`, `ERROR: cannot flatten an ARRAY holding itself`},
+		{`let a = [1]; for (i in range(40)) { let a = [a, a] }; flatten(a, 100)`, "ERROR: result of `flatten` too large: more than 16777216 elements"},
+		{`uniq([1, 2, 1, "a", "a", [1], [1]])`, `[1, 2, a, [1]]`},
+		{`slice([1, 2, 3, 4], 1)`, `[2, 3, 4]`},
+		{`slice([1, 2, 3, 4], 1, 3)`, `[2, 3]`},
+		{`slice([1, 2, 3, 4], -2)`, `[3, 4]`},
+		{`slice([1, 2, 3, 4], 3, 1)`, `[]`},
+		{`slice([1, 2, 3, 4], -10, 10)`, `[1, 2, 3, 4]`},
+		{`index_of([1, 2, 3], 2)`, `1`},
+		{`index_of([[1]], [1])`, `0`},
+		{`index_of([1, 2, 3], 4)`, `-1`},
+		{`join([1, "a", true])`, `1atrue`},
+		{`join(["a", "b"], ", ")`, `a, b`},
+		{`map([1, 0], fn(x) { 1 / x })`, `ERROR: division by zero`},
+		{`map([1], fn(x, y) { x })`, `ERROR: wrong number of arguments: want=2, got=1`},
+		{`map([1], 1)`, `ERROR: not a function: INTEGER`},
+		{`map(1, len)`, "ERROR: argument to `map` must be ARRAY, got INTEGER"},
+		{`filter([1], len)`, "ERROR: argument to `len` not supported, got INTEGER"},
+		{`sort([1, "a"])`, `ERROR: cannot compare STRING and INTEGER`},
+		{`sort([1, 2], fn(a, b) { "x" })`, `ERROR: comparator must return BOOLEAN or INTEGER, got STRING`},
+		{`range(1, 2, 0)`, `ERROR: range step must not be zero`},
+		{`range(0, 2000000000)`, "ERROR: result of `range` too large: 2000000000 elements, at most 16777216"},
+		{`join(["a"], 1)`, "ERROR: separator of `join` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		if got := testRun(t, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len("héllo")`, `5`},
+		{`"héllo"[1]`, `é`},
+		{`"héllo"[4]`, `o`},
+		{`"héllo"[5]`, `null`},
+		{`"héllo"[-1]`, `null`},
+		{`let s = ""; for (c in "日本") { s = c + s }; s`, `本日`},
+		{`split("a,b,,c", ",")`, `[a, b, , c]`},
+		{`split("  a b\tc ")`, `[a, b, c]`},
+		{`split("日本", "")`, `[日, 本]`},
+		{`join(split("a b c"), "-")`, `a-b-c`},
+		{`trim("  a b  ")`, `a b`},
+		{`trim("xxaxx", "x")`, `a`},
+		{`upper("héllo")`, `HÉLLO`},
+		{`lower("ÀB")`, `àb`},
+		{`replace("a-b-c", "-", "+")`, `a+b+c`},
+		{`replace("a-b-c", "-", "+", 1)`, `a+b-c`},
+		{`contains("héllo", "él")`, `true`},
+		{`contains("héllo", "x")`, `false`},
+		{`starts_with("héllo", "hé")`, `true`},
+		{`ends_with("héllo", "hé")`, `false`},
+		{`index("日本語", "語")`, `2`},
+		{`index("日本語", "x")`, `-1`},
+		{`substr("héllo", 1)`, `éllo`},
+		{`substr("héllo", 1, 3)`, `éll`},
+		{`substr("héllo", -2, 10)`, `lo`},
+		{`substr("héllo", 10)`, ``},
+		{`slice("héllo", 1, -1)`, `éll`},
+		{`repeat("ab", 3)`, `ababab`},
+		{`repeat("ab", 0)`, ``},
+		{`pad_left("é", 3)`, `  é`},
+		{`pad_right("é", 4, "ab")`, `éaba`},
+		{`pad_left("héllo", 2, "0")`, `héllo`},
+		{`chars("hé")`, `[h, é]`},
+		{`chars("")`, `[]`},
+		{`format("%s is %d years", "Ann", 42)`, `Ann is 42 years`},
+		{`format("%5.2f|%-4s|%x|%t|%q|100%%", 3.14159, "é", 255, true, "a")`, ` 3.14|é   |ff|true|"a"|100%`},
+		{`format("%v %s", [1, "a"], {"k": 1})`, `[1, a] {k: 1}`},
+		{`format("%.1f", 2)`, `2.0`},
+		{`format("%d", "a")`, `ERROR: format: cannot format STRING with %d`},
+		{`format("%d %d", 1)`, `ERROR: format: missing argument for %d`},
+		{`format("%d", 1, 2)`, `ERROR: format: 1 arguments left over`},
+		{`format("%y", 1)`, `ERROR: format: unknown verb %y`},
+		{`format("100%")`, `ERROR: format: missing verb at the end of "100%"`},
+		{`upper(1)`, "ERROR: argument to `upper` must be STRING, got INTEGER"},
+		{`split("a", 1)`, "ERROR: argument to `split` must be STRING, got INTEGER"},
+		{`repeat("a", -1)`, "ERROR: count of `repeat` must not be negative, got -1"},
+		{`substr("a", 0, -1)`, "ERROR: length of `substr` must not be negative, got -1"},
+		{`pad_left("a", 3, "")`, "ERROR: padding of `pad_left` must not be empty"},
+		{`"abc"["a"]`, `ERROR: index operator not supported: STRING`},
+	}
+
+	for _, tt := range tests {
+		if got := testRun(t, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2 * 2, 3 + 3]", []int{1, 4, 6}},
+		{"len([" + strings.Repeat("1, ", 69999) + "1])", 70000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{`let two = "two";
+		{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+		}`, map[object.HashKey]int64{
+			(&object.String{Value: "one"}).HashKey():   1,
+			(&object.String{Value: "two"}).HashKey():   2,
+			(&object.String{Value: "three"}).HashKey(): 3,
+			(&object.Integer{Value: 4}).HashKey():      4,
+			True.HashKey():                             5,
+			False.HashKey():                            6,
+		}},
+		{"{}", map[object.HashKey]int64{}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; let sum = 0; while (i < 5) { let i = i + 1; let sum = sum + i; }; sum", 15},
+		{"let i = 0; while (true) { if (i == 3) { break; } let i = i + 1; }; i", 3},
+		{"let i = 0; let sum = 0; while (i < 6) { let i = i + 1; if (i == 3) { continue; } let sum = sum + i; }; sum", 18},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { let sum = sum + i * x; }; sum", 80},
+		{"let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { continue; } let sum = sum + x; }; sum", 4},
+		{`let n = 0; for (c in "héllo") { let n = n + 1; }; n`, 5},
+		{`let n = 0; for (i, c in "abc") { let n = n + i; }; n`, 3},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2}) { let sum = sum + v; }; sum`, 3},
+		{"let sum = 0; for (k in {1: 10, 2: 20}) { let sum = sum + k; }; sum", 3},
+		{`let n = 0;
+		for (i in [1, 2, 3]) {
+			for (j in [1, 2, 3]) {
+				if (j == 2) { break; }
+				let n = n + 1;
+			}
+		};
+		n`, 3},
+		{`let f = fn() {
+			for (x in [1, 2, 3]) {
+				if (x == 2) { return x * 10; }
+			}
+			return 0;
+		};
+		f()`, 20},
+		{`let f = fn(n) {
+			let i = 0;
+			let acc = 1;
+			while (i < n) { let i = i + 1; let acc = acc * 2; }
+			acc
+		};
+		f(5)`, 32},
+		{"for (x in 5) { x }", vmError("not iterable: INTEGER")},
+		{"let f = fn() { while (false) { 1 } }; f()", nil},
+		{"1; while (false) { 1 }", nil},
+		{"1; for (x in [2, 3]) { x }", nil},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x += 2; x", 3},
+		{"let x = 10; x -= 3; x *= 2; x /= 7; x", 2},
+		{"let x = 17; x %= 5; x", 2},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let x = 1; let y = (x = 4) + 1; x + y", 9},
+		{"let arr = [1, 2, 3]; arr[1] = 20; arr[1]", 20},
+		{"let arr = [1, 2, 3]; arr[2] += 5; arr[2]", 8},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += 10; h["a"] + h["b"]`, 13},
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next(); next()", 3},
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let a = counter(); let b = counter(); a(); a(); b(); a()", 3},
+		{"let f = fn() { let x = 1; let inc = fn() { x = x + 10 }; inc(); x }; f()", 11},
+		{"let total = 0; let add = fn(n) { total += n }; add(3); add(4); total", 7},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i; }; sum", 15},
+		{"let f = fn() { let i = 0; while (i < 3) { i = i + 1 }; i }; f()", 3},
+		{"x = 5", vmError("assignment to undeclared identifier: x")},
+		{"x += 5", vmError("identifier not found: x")},
+		{"let f = fn() { y = 1 }; f()", vmError("assignment to undeclared identifier: y")},
+		{"let a = [1]; a[3] = 1", vmError("index out of range: 3")},
+		{"let s = 1; s[0] = 1", vmError("index assignment not supported: INTEGER")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloats(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1e3", 1000.0},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"let m = 7.5; m %= 2; m", 1.5},
+		{"10 - 2.5 * 2", 5.0},
+		{"let avg = fn(a, b) { (a + b) / 2.0 }; avg(1, 2)", 1.5},
+		{"let x = 1; x += 0.25; x", 1.25},
+		{"float(3)", 3.0},
+		{`float("2.5")`, 2.5},
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{`int("42")`, 42},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"{1: 10}[1.0]", 10},
+		{`float("abc")`, vmError("cannot convert \"abc\" to FLOAT")},
+		{"int(1e300)", vmError("cannot convert 1e+300 to INTEGER")},
+		{"int(-9.3e18)", vmError("cannot convert -9.3e+18 to INTEGER")},
+		{"int(-9.2e18)", -9200000000000000000},
+		{"int(true)", vmError("argument to `int` not supported, got BOOLEAN")},
+		{"1.5 + true", vmError("type mismatch: FLOAT + BOOLEAN")},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLogicalAndComparisonOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && false", false},
+		{"true || false", true},
+		{"1 && 2", 2},
+		{"false || 0", 0},
+		{"false && x", false},
+		{"true || x", true},
+		{"let n = 0; let inc = fn() { n += 1; true }; false && inc(); true || inc(); n", 0},
+		{"let n = 0; let inc = fn() { n += 1; true }; true && inc(); false || inc(); n", 2},
+		{"let a = [1, 2, 3]; let x = 2; x >= 0 && x < len(a)", true},
+		{"let a = [1, 2, 3]; let x = 3; x >= 0 && x < len(a)", false},
+		{"1 + 1 == 2 && 3 > 2 || false", true},
+		{"if (1 > 2 || 2 >= 2) { 10 } else { 20 }", 10},
+		{"3 <= 3", true},
+		{"4 >= 5", false},
+		{"2.5 >= 2", true},
+		{"1 <= 0.5", false},
+		{"10 % 3", 1},
+		{"-7 % 3", -1},
+		{"7.5 % 2", 1.5},
+		{"2 + 10 % 4 * 2", 6},
+		{"1 <= true", vmError("type mismatch: INTEGER <= BOOLEAN")},
+	}
+
+	runVmTests(t, tests)
+}